@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/artifact"
+	"github.com/alx99/botpot/internal/botpot/asciicast"
+	"github.com/alx99/botpot/internal/botpot/config"
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// errNoDatabase is returned by the commands reading the database if it is disabled
+var errNoDatabase = errors.New("PG_HOST is not set, the command reads from the database")
+
+// runCommand runs one of the commands meant for analysts
+func runCommand(cfg config.Config, args []string) error {
+	switch args[0] {
+	case "artifact":
+		return artifactCommand(cfg, args[1:])
+	case "console":
+		return consoleCommand(cfg, args[1:])
+	case "asciicast":
+		return asciicastCommand(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// artifactCommand prints what is known about an artifact
+// and optionally writes its content to a file
+func artifactCommand(cfg config.Config, args []string) (err error) {
+	fs := flag.NewFlagSet("artifact", flag.ContinueOnError)
+	output := fs.String("o", "", "write the content of the artifact to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: botpot artifact [-o file] <sha256>")
+		fs.PrintDefaults()
+	}
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one hash")
+	}
+	hash := fs.Arg(0)
+
+	database := db.NewDB(cfg.PGHost)
+	if !database.Enabled() {
+		return errNoDatabase
+	}
+	if err = database.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, database.Stop())
+	}()
+
+	var info artifact.Info
+	err = database.BeginTx(func(tx pgx.Tx) (err error) {
+		info, err = artifact.Lookup(tx, hash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sha256:     %s\n", info.SHA256)
+	fmt.Printf("size:       %d\n", info.Size)
+	fmt.Printf("first seen: %s\n", info.FirstSeen.Format(time.RFC3339))
+	fmt.Printf("last seen:  %s\n", info.LastSeen.Format(time.RFC3339))
+	fmt.Printf("times seen: %d\n", info.TimesSeen)
+	fmt.Println("sightings:")
+	for _, s := range info.Sightings {
+		fmt.Printf("  %s session %d %s %s\n", s.TS.Format(time.RFC3339), s.SessionID, s.Source, s.Path)
+	}
+
+	if *output == "" {
+		return nil
+	}
+	store, err := artifact.NewFSStore(cfg.ArtifactDir)
+	if err != nil {
+		return err
+	}
+	data, err := store.Get(info.SHA256)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, data, 0o600)
+}
+
+// asciicastCommand converts the recording of a session to asciicast v2
+func asciicastCommand(cfg config.Config, args []string) (err error) {
+	fs := flag.NewFlagSet("asciicast", flag.ContinueOnError)
+	output := fs.String("o", "", "write the cast to this file instead of stdout")
+	source := fs.String("source", "script", "convert the script output of the host (script) or the data of a channel (channel)")
+	channel := fs.Uint("channel", 0, "channel to convert, defaults to the first channel a pty was requested on")
+	input := fs.Bool("input", false, "include the data sent by the attacker as input events, only for channels")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: botpot asciicast [-o file] [-source script|channel] [-channel id] [-input] <session id>")
+		fs.PrintDefaults()
+	}
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one session")
+	}
+	sessionID, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid session id: %w", err)
+	}
+	if *source != "script" && *source != "channel" {
+		return fmt.Errorf("unknown source %q", *source)
+	}
+
+	database := db.NewDB(cfg.PGHost)
+	if !database.Enabled() {
+		return errNoDatabase
+	}
+	if err = database.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, database.Stop())
+	}()
+
+	var cast *asciicast.Cast
+	err = database.BeginTx(func(tx pgx.Tx) (err error) {
+		if *source == "script" {
+			cast, err = asciicast.LoadScript(tx, sessionID)
+		} else {
+			cast, err = asciicast.LoadChannel(tx, sessionID, uint32(*channel), *input)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return cast.Encode(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	return cast.Encode(f)
+}
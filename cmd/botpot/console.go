@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/config"
+	"github.com/alx99/botpot/internal/botpot/console"
+)
+
+// killLine is the line operators enter to kill the channel they have taken over
+const killLine = "~."
+
+// operatorClient talks to the console of a running botpot
+type operatorClient struct {
+	url      string
+	token    string
+	operator string
+}
+
+// consoleCommand lists the live channels, or watches one of them and
+// optionally takes it over by sending stdin to the attacker
+func consoleCommand(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("console", flag.ContinueOnError)
+	baseURL := fs.String("url", "http://"+localAddr(cfg.HTTPAddr), "URL of the botpot HTTP server")
+	operator := fs.String("operator", os.Getenv("USER"), "name of the operator in OPERATORS to authenticate as")
+	input := fs.Bool("input", false, "also show what the attacker sends")
+	takeover := fs.Bool("takeover", false, "send lines read from stdin to the attacker, "+killLine+" kills the channel")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: botpot console [flags] [<session> <channel>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	token, ok := cfg.Operators[*operator]
+	if !ok {
+		return fmt.Errorf("operator %q is not in OPERATORS", *operator)
+	}
+	c := operatorClient{url: *baseURL, token: token, operator: *operator}
+
+	switch fs.NArg() {
+	case 0:
+		return c.list()
+	case 2:
+		return c.watch(fs.Arg(0), fs.Arg(1), *input, *takeover)
+	default:
+		fs.Usage()
+		return errors.New("expected a session and a channel")
+	}
+}
+
+// list prints the live channels
+func (c operatorClient) list() error {
+	res, err := c.do(http.MethodGet, "/console/channels", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var channels []console.LiveChannel
+	if err = json.NewDecoder(res.Body).Decode(&channels); err != nil {
+		return err
+	}
+	for _, ch := range channels {
+		fmt.Printf("%s  %s  %4d  %s\n", ch.Started.Format(time.RFC3339), ch.Session, ch.ID, ch.Type)
+	}
+	return nil
+}
+
+// watch copies the channel to stdout until it closes
+func (c operatorClient) watch(session, channel string, input, takeover bool) error {
+	q := url.Values{"session": {session}, "channel": {channel}}
+	if input {
+		q.Set("input", "true")
+	}
+	res, err := c.do(http.MethodGet, "/console/watch", q, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if takeover {
+		go c.takeover(q)
+	}
+	_, err = io.Copy(os.Stdout, res.Body)
+	return err
+}
+
+// takeover sends the lines read from stdin to the attacker
+func (c operatorClient) takeover(q url.Values) {
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		if s.Text() == killLine {
+			if res, err := c.do(http.MethodPost, "/console/kill", q, nil); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not kill channel:", err)
+			} else {
+				res.Body.Close()
+			}
+			return
+		}
+
+		// The attacker's terminal expects carriage returns
+		res, err := c.do(http.MethodPost, "/console/inject", q, strings.NewReader(s.Text()+"\r\n"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not inject data:", err)
+			continue
+		}
+		res.Body.Close()
+	}
+}
+
+func (c operatorClient) do(method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url+path+"?"+q.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.operator, c.token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// localAddr returns an address the HTTP server listening on addr can be reached at locally
+func localAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
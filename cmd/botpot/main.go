@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -10,13 +12,23 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/admin"
+	"github.com/alx99/botpot/internal/botpot/artifact"
 	"github.com/alx99/botpot/internal/botpot/config"
+	"github.com/alx99/botpot/internal/botpot/console"
 	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
 	"github.com/alx99/botpot/internal/botpot/hostprovider"
+	"github.com/alx99/botpot/internal/botpot/replay"
+	"github.com/alx99/botpot/internal/botpot/sinkhole"
 	"github.com/alx99/botpot/internal/botpot/ssh"
+	"github.com/alx99/botpot/internal/botpot/ssh/auth"
+	"github.com/alx99/botpot/internal/botpot/ssh/channel"
+	"github.com/alx99/botpot/internal/botpot/web"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -35,6 +47,13 @@ func init() {
 
 func main() {
 	cfg := setup()
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1:]); err != nil {
+			log.Fatal().Err(err).Msg("Command failed")
+		}
+		return
+	}
+
 	log.Info().Str("commitHash", commitHash).Str("compilationDate", compilationDate).
 		Msgf("Botpot started!")
 
@@ -57,14 +76,81 @@ func main() {
 		},
 		specs.Platform{},
 		cfg.HostBuffer,
+		cfg.ArtifactMaxSize,
 	)
 
-	db := db.NewDB(cfg.PGHost)
-	sshServer := ssh.New(cfg.SSHServerVersion, cfg.Port, cfg.SSHHostKeys, provider, &db)
+	// Data is only stored in PostgreSQL if it is one of the event sinks
+	pgHost := ""
+	for _, name := range cfg.EventSinks {
+		if name == "postgres" {
+			pgHost = cfg.PGHost
+		}
+	}
+	db := db.NewDB(pgHost)
+
+	var webServer *web.Server
+	var stream *event.Stream
+	if cfg.HTTPAddr != "" {
+		webServer = web.New(cfg.HTTPAddr)
+		stream = event.NewStream()
+	}
 
-	err := db.Start()
+	sinks, err := eventSinks(cfg, &db, stream)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Could not start database")
+		log.Fatal().Err(err).Msg("Could not create event sinks")
+	}
+	events := event.NewDispatcher(cfg.EventBuffer, sinks...)
+
+	console := console.New(&db, events)
+	if webServer != nil && cfg.MetricsToken != "" {
+		webServer.Handle("/metrics", web.RequireToken(cfg.MetricsToken, promhttp.Handler()))
+	}
+	if webServer != nil && len(cfg.Operators) > 0 {
+		webServer.Handle("/events", web.RequireOperator(cfg.Operators, stream))
+		webServer.Handle("/console/", web.RequireOperator(cfg.Operators, console))
+		if db.Enabled() {
+			ui := web.RequireOperator(cfg.Operators, replay.New(&db))
+			webServer.Handle("/sessions", ui)
+			webServer.Handle("/sessions/", ui)
+		}
+	}
+
+	policy, err := auth.NewPolicy(cfg.AuthAcceptAfter, cfg.AuthWordlist, cfg.AuthDenyUsers, cfg.AuthProbability)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create authentication policy")
+	}
+
+	store, err := artifact.NewFSStore(cfg.ArtifactDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create artifact store")
+	}
+
+	sinkholes := map[string]channel.Sinkhole{
+		"smtp": sinkhole.NewSMTP(&db, cfg.SMTPHostname, cfg.SMTPMaxSize),
+		"http": sinkhole.NewHTTP(&db, sinkhole.Response{
+			Headers: http.Header{"Content-Type": {cfg.HTTPContentType}, "Server": {"nginx/1.18.0 (Ubuntu)"}},
+			Body:    cfg.HTTPBody,
+			Status:  cfg.HTTPStatus,
+		}),
+	}
+	forward, err := channel.ParseForwardPolicy(cfg.ForwardRules, sinkholes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not parse forwarding rules")
+	}
+
+	limits := channel.Limits{
+		SpillDir: cfg.CaptureSpillDir,
+		Channel:  cfg.ChannelCaptureLimit,
+		Session:  cfg.SessionCaptureLimit,
+	}
+	sshServer := ssh.New(cfg.SSHServerVersion, cfg.Port, cfg.SSHHostKeys, provider, policy, limits, forward, store, &db, events, console)
+	if webServer != nil && cfg.AdminToken != "" {
+		webServer.Handle("/admin/", web.RequireToken(cfg.AdminToken, admin.New(provider, sshServer)))
+	}
+
+	err = events.Start()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not start event sinks")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -79,6 +165,12 @@ func main() {
 		log.Fatal().Err(err).Msg("Could not start SSH Server")
 	}
 
+	if webServer != nil {
+		if err = webServer.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Could not start HTTP Server")
+		}
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
@@ -95,10 +187,56 @@ func main() {
 	}
 	cancel()
 
-	err = db.Stop()
+	// Stopping the sinks disconnects from the database and
+	// ends the event streams, letting the HTTP Server shut down
+	err = events.Stop()
 	if err != nil {
-		log.Err(err).Msg("Could not stop database")
+		log.Err(err).Msg("Could not stop event sinks")
+	}
+
+	if webServer != nil {
+		if err = webServer.Stop(); err != nil {
+			log.Err(err).Msg("Could not stop HTTP Server")
+		}
+	}
+}
+
+// eventSinks creates the configured event sinks. The stream
+// is added if the events are streamed over HTTP.
+func eventSinks(cfg config.Config, database db.Store, stream *event.Stream) ([]event.Sink, error) {
+	sinks := []event.Sink{}
+	for _, name := range cfg.EventSinks {
+		switch name {
+		case "postgres":
+			if !database.Enabled() {
+				return nil, errors.New("PG_HOST is required by the postgres sink")
+			}
+			sinks = append(sinks, database)
+		case "jsonl":
+			if cfg.EventJSONLFile == "" {
+				return nil, errors.New("EVENT_JSONL_FILE is required by the jsonl sink")
+			}
+			sinks = append(sinks, event.NewJSONL(cfg.EventJSONLFile))
+		case "syslog":
+			sinks = append(sinks, event.NewSyslog(cfg.EventSyslogNetwork, cfg.EventSyslogAddr, "botpot"))
+		case "webhook":
+			if cfg.EventWebhookURL == "" {
+				return nil, errors.New("EVENT_WEBHOOK_URL is required by the webhook sink")
+			}
+			headers := http.Header{}
+			if cfg.EventWebhookAuth != "" {
+				headers.Set("Authorization", cfg.EventWebhookAuth)
+			}
+			sinks = append(sinks, event.NewWebhook(cfg.EventWebhookURL, headers, time.Duration(cfg.EventWebhookTimeout)*time.Second))
+		default:
+			return nil, fmt.Errorf("unknown event sink %q", name)
+		}
+	}
+
+	if stream != nil {
+		sinks = append(sinks, stream)
 	}
+	return sinks, nil
 }
 
 func setup() config.Config {
@@ -5,15 +5,16 @@ CREATE TABLE IP (
 
 CREATE TABLE Session (
     id SERIAL NOT NULL,
+    uid TEXT NOT NULL UNIQUE, -- Identifies the session in all event sinks
     version TEXT NOT NULL,
-    stdout TEXT NOT NULL, -- Related to script
-    timing TEXT NOT NULL, -- Related to script
+    stdout TEXT NOT NULL DEFAULT '', -- Related to script
+    timing TEXT NOT NULL DEFAULT '', -- Related to script
     src_ip inet NOT NULL,
     src_port INT NOT NULL,
     dst_ip inet NOT NULL,
     dst_port INT NOT NULL,
     start_ts timestamptz NOT NULL,
-    end_ts timestamptz NOT NULL,
+    end_ts timestamptz, -- NULL while active
     PRIMARY KEY (id),
     CONSTRAINT fk_ip FOREIGN KEY (src_ip) REFERENCES IP (ip_address) ON DELETE CASCADE,
     CONSTRAINT valid_port CHECK (
@@ -23,25 +24,272 @@ CREATE TABLE Session (
     CONSTRAINT end_time_after_start_time CHECK (start_ts <= end_ts)
 );
 
+CREATE TABLE Credential (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    attempt INT NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    username TEXT NOT NULL,
+    password TEXT NOT NULL,
+    client_version TEXT NOT NULL,
+    ts timestamptz NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+CREATE TABLE PublicKey (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    attempt INT NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    username TEXT NOT NULL,
+    key_type TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    authorized_key TEXT NOT NULL,
+    client_version TEXT NOT NULL,
+    ts timestamptz NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+CREATE INDEX publickey_fingerprint ON PublicKey (fingerprint);
+
+CREATE TABLE KeyboardInteractive (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    attempt INT NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    username TEXT NOT NULL,
+    instruction TEXT NOT NULL,
+    questions TEXT[] NOT NULL,
+    answers TEXT[] NOT NULL,
+    client_version TEXT NOT NULL,
+    ts timestamptz NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+CREATE TABLE GlobalRequest (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    ts timestamptz NOT NULL,
+    from_client BOOLEAN NOT NULL,
+    type TEXT NOT NULL,
+    want_reply BOOLEAN NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    bind_address TEXT, -- Only set for (cancel-)tcpip-forward
+    bind_port BIGINT,
+    bound_port BIGINT, -- Port allocated by the server when bind_port is 0
+    payload BYTEA NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+-- Events are written independently of the session and may arrive before
+-- it has been inserted, hence no foreign key to Session
+CREATE TABLE Event (
+    id SERIAL NOT NULL,
+    session_uid TEXT NOT NULL,
+    channel_id BIGINT, -- NULL for events not tied to a channel
+    ts timestamptz NOT NULL,
+    type TEXT NOT NULL,
+    data JSONB NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX event_session_uid ON Event (session_uid);
+
+CREATE TABLE OperatorAction (
+    id SERIAL NOT NULL,
+    session_uid TEXT NOT NULL,
+    channel_id BIGINT NOT NULL,
+    ts timestamptz NOT NULL,
+    operator TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    action TEXT NOT NULL, -- watch, inject or kill
+    data BYTEA, -- Injected data
+    PRIMARY KEY (id),
+    CONSTRAINT fk_session_uid FOREIGN KEY (session_uid) REFERENCES Session (uid) ON DELETE CASCADE
+);
+
+CREATE TABLE Blob (
+    sha256 TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    first_seen timestamptz NOT NULL,
+    last_seen timestamptz NOT NULL,
+    times_seen INT NOT NULL DEFAULT 1,
+    PRIMARY KEY (sha256)
+);
+
+CREATE TABLE BlobSighting (
+    id SERIAL NOT NULL,
+    sha256 TEXT NOT NULL,
+    session_id INT NOT NULL,
+    source TEXT NOT NULL, -- container, sftp or scp
+    path TEXT NOT NULL,
+    ts timestamptz NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_sha256 FOREIGN KEY (sha256) REFERENCES Blob (sha256) ON DELETE CASCADE,
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+CREATE INDEX blobsighting_sha256 ON BlobSighting (sha256);
+
+CREATE TABLE Artifact (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    ts timestamptz NOT NULL,
+    path TEXT NOT NULL,
+    added BOOLEAN NOT NULL, -- false if modified
+    size BIGINT NOT NULL,
+    mode TEXT NOT NULL,
+    mod_time timestamptz NOT NULL,
+    sha256 TEXT, -- NULL if the content was not exported
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE
+);
+
+CREATE INDEX artifact_sha256 ON Artifact (sha256);
+
 CREATE TABLE Channel (
     id INT NOT NULL,
     session_id INT NOT NULL,
     channel_type TEXT NOT NULL,
-    recv BYTEA,
-    recv_stderr BYTEA,
+    from_client BOOLEAN NOT NULL DEFAULT TRUE, -- false if opened by the honeypot
     start_ts timestamptz NOT NULL,
-    end_ts timestamptz NOT NULL,
+    end_ts timestamptz, -- NULL while active
     PRIMARY KEY (id, session_id),
     CONSTRAINT fk_id FOREIGN KEY (session_id) REFERENCES Session (id) ON DELETE CASCADE,
     CONSTRAINT end_time_after_start_time CHECK (start_ts <= end_ts)
 );
 
+CREATE TABLE TCPIPChannel (
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    address TEXT NOT NULL, -- Where to connect for direct-tcpip, what was connected to for forwarded-tcpip
+    port BIGINT NOT NULL,
+    originator_address TEXT NOT NULL,
+    originator_port BIGINT NOT NULL,
+    action TEXT NOT NULL DEFAULT 'allow', -- What the forwarding policy decided
+    PRIMARY KEY (channel_id, session_id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE INDEX tcpipchannel_destination ON TCPIPChannel (address, port);
+
+CREATE TABLE SinkholeMail (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    ts timestamptz NOT NULL,
+    helo TEXT NOT NULL,
+    auth_user TEXT NOT NULL,
+    auth_password TEXT NOT NULL,
+    mail_from TEXT NOT NULL,
+    rcpt_to TEXT[] NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE TABLE SinkholeHTTPRequest (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    ts timestamptz NOT NULL,
+    method TEXT NOT NULL,
+    host TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    proto TEXT NOT NULL,
+    headers JSONB NOT NULL,
+    body BYTEA NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE TABLE ChannelData (
+    id BIGSERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    ts timestamptz NOT NULL,
+    from_client BOOLEAN NOT NULL,
+    stderr BOOLEAN NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE INDEX channeldata_channel ON ChannelData (session_id, channel_id, ts);
+
+-- Present for the directions of a channel that exceeded the capture limits
+CREATE TABLE ChannelTruncation (
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    from_client BOOLEAN NOT NULL,
+    stderr BOOLEAN NOT NULL,
+    truncated_at BIGINT NOT NULL,
+    spill TEXT, -- File containing the data exceeding the capture limits
+    PRIMARY KEY (session_id, channel_id, from_client, stderr),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE TABLE SFTPOperation (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    request_id BIGINT NOT NULL, -- SFTP request ID
+    operation TEXT NOT NULL,
+    path TEXT NOT NULL,
+    target_path TEXT NOT NULL, -- Set for rename and links
+    flags BIGINT NOT NULL,
+    attrs JSONB,
+    handle BYTEA NOT NULL,
+    status_code INT, -- NULL unless the reply was a status
+    status_message TEXT,
+    request_ts timestamptz,
+    reply_ts timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE TABLE FileTransfer (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    protocol TEXT NOT NULL,
+    upload BOOLEAN NOT NULL, -- false if downloaded
+    path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 TEXT NOT NULL, -- Content is kept in the artifact store if it has a Blob
+    mode INT, -- Only known for scp
+    complete BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
+CREATE TABLE TransferAnomaly (
+    id SERIAL NOT NULL,
+    session_id INT NOT NULL,
+    channel_id INT NOT NULL,
+    protocol TEXT NOT NULL,
+    ts timestamptz NOT NULL,
+    from_client BOOLEAN NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
+);
+
 CREATE TABLE Request (
     id SERIAL NOT NULL,
     channel_id INT NOT NULL,
     session_id INT NOT NULL,
     ts timestamptz NOT NULL,
     from_client BOOLEAN NOT NULL,
+    type TEXT NOT NULL,
+    want_reply BOOLEAN NOT NULL,
+    accepted BOOLEAN, -- NULL if no reply was wanted
+    reply_error TEXT, -- Set if the request could not be proxied
+    latency INTERVAL NOT NULL, -- Time until the proxy replied
     PRIMARY KEY (id),
     CONSTRAINT fk_id FOREIGN KEY (channel_id, session_id) REFERENCES Channel (id, session_id) ON DELETE CASCADE
 );
@@ -112,3 +360,41 @@ CREATE TABLE SubSystemRequest (
     PRIMARY KEY (request_id),
     CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
 );
+
+CREATE TABLE X11Request (
+    request_id INT NOT NULL,
+    single_connection BOOLEAN NOT NULL,
+    auth_protocol TEXT NOT NULL,
+    auth_cookie TEXT NOT NULL,
+    screen_number BIGINT NOT NULL,
+    PRIMARY KEY (request_id),
+    CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
+);
+
+CREATE TABLE SignalRequest (
+    request_id INT NOT NULL,
+    signal_name TEXT NOT NULL,
+    PRIMARY KEY (request_id),
+    CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
+);
+
+CREATE TABLE FlowControlRequest (
+    request_id INT NOT NULL,
+    client_can_do BOOLEAN NOT NULL,
+    PRIMARY KEY (request_id),
+    CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
+);
+
+CREATE TABLE BreakRequest (
+    request_id INT NOT NULL,
+    break_length BIGINT NOT NULL, -- In milliseconds
+    PRIMARY KEY (request_id),
+    CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
+);
+
+CREATE TABLE RawRequest (
+    request_id INT NOT NULL,
+    payload BYTEA NOT NULL,
+    PRIMARY KEY (request_id),
+    CONSTRAINT fk_id FOREIGN KEY (request_id) REFERENCES Request (id) ON DELETE CASCADE
+);
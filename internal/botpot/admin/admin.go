@@ -0,0 +1,154 @@
+// Package admin serves the API used to inspect and
+// control botpot while it is running
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/alx99/botpot/internal/botpot/hostprovider"
+	"github.com/alx99/botpot/internal/botpot/ssh"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// API serves the admin endpoints
+type API struct {
+	provider *hostprovider.DockerProvider
+	server   *ssh.Server
+	mux      *http.ServeMux
+}
+
+// Status is the state of botpot
+type Status struct {
+	Image      string `json:"image"`
+	Sessions   int    `json:"sessions"`
+	Containers int    `json:"containers"`
+	HostBuffer int    `json:"host_buffer"`
+	Draining   bool   `json:"draining"`
+}
+
+// New creates a new admin API controlling the provider and server
+func New(provider *hostprovider.DockerProvider, server *ssh.Server) *API {
+	a := &API{
+		provider: provider,
+		server:   server,
+		mux:      http.NewServeMux(),
+	}
+	a.mux.HandleFunc("/admin/status", allow(http.MethodGet, a.handleStatus))
+	a.mux.HandleFunc("/admin/sessions", allow(http.MethodGet, a.handleSessions))
+	a.mux.HandleFunc("/admin/containers", allow(http.MethodGet, a.handleContainers))
+	a.mux.HandleFunc("/admin/buffer", allow(http.MethodPost, a.handleBuffer))
+	a.mux.HandleFunc("/admin/image", allow(http.MethodPost, a.handleImage))
+	a.mux.HandleFunc("/admin/drain", allow(http.MethodPost, a.handleDrain))
+	a.mux.HandleFunc("/admin/resume", allow(http.MethodPost, a.handleResume))
+	a.mux.HandleFunc("/admin/disconnect", allow(http.MethodPost, a.handleDisconnect))
+	return a
+}
+
+// ServeHTTP serves the admin endpoints
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) status() Status {
+	return Status{
+		Image:      a.provider.Image(),
+		Sessions:   len(a.server.Sessions()),
+		Containers: len(a.provider.Containers()),
+		HostBuffer: a.provider.HostBuffer(),
+		Draining:   a.server.Draining(),
+	}
+}
+
+func (a *API) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.status())
+}
+
+func (a *API) handleSessions(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.server.Sessions())
+}
+
+func (a *API) handleContainers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.provider.Containers())
+}
+
+// handleBuffer resizes the host buffer to the size query parameter
+func (a *API) handleBuffer(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || n < 0 {
+		http.Error(w, "size must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	audit(r).Int("hostBuffer", n).Msg("Admin resized host buffer")
+	a.provider.SetHostBuffer(n)
+	writeJSON(w, a.status())
+}
+
+// handleImage rotates the honeypot image to the name query parameter
+func (a *API) handleImage(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	audit(r).Str("image", name).Msg("Admin rotated honeypot image")
+	if err := a.provider.SetImage(r.Context(), name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, a.status())
+}
+
+func (a *API) handleDrain(w http.ResponseWriter, r *http.Request) {
+	audit(r).Msg("Admin drained server")
+	a.server.SetDraining(true)
+	writeJSON(w, a.status())
+}
+
+func (a *API) handleResume(w http.ResponseWriter, r *http.Request) {
+	audit(r).Msg("Admin resumed server")
+	a.server.SetDraining(false)
+	writeJSON(w, a.status())
+}
+
+// handleDisconnect disconnects the client of the session query parameter
+func (a *API) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("session")
+	audit(r).Str("session", uid).Msg("Admin disconnected session")
+	err := a.server.Disconnect(uid)
+	switch {
+	case errors.Is(err, ssh.ErrUnknownSession):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Err(err).Str("session", uid).Msg("Error while disconnecting session")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allow only lets requests of method through to h
+func allow(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// audit logs an action taken through the API
+func audit(r *http.Request) *zerolog.Event {
+	return log.Warn().Str("remoteAddr", r.RemoteAddr)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	// nolint:errcheck // nothing to do if the client is gone
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,35 @@
+package artifact
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Artifact is a file left behind on a host by an attacker
+type Artifact struct {
+	TS      time.Time
+	ModTime time.Time
+	SHA256  *string // nil if the content was not exported
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	Added   bool // false if modified
+}
+
+// Insert tries to insert the artifact into the database
+func (a *Artifact) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO Artifact(session_id, ts, path, added, size, mode, mod_time, sha256)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+`, sessionID, a.TS, db.Sanitize(a.Path), a.Added, a.Size, a.Mode.String(), a.ModTime, a.SHA256)
+	if err != nil || a.SHA256 == nil {
+		return err
+	}
+
+	s := Sighting{TS: a.TS, SHA256: *a.SHA256, Source: SourceContainer, Path: a.Path, Size: a.Size}
+	return s.Insert(tx, sessionID)
+}
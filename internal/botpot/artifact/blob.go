@@ -0,0 +1,96 @@
+package artifact
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Sources of blobs
+const (
+	SourceContainer = "container"
+	SourceSFTP      = "sftp"
+	SourceSCP       = "scp"
+)
+
+// ErrNotFound is returned when looking up a blob that has never been seen
+var ErrNotFound = errors.New("artifact not found")
+
+// Sighting is an occurrence of a blob in a session
+type Sighting struct {
+	TS        time.Time
+	SHA256    string
+	Source    string
+	Path      string
+	Size      int64
+	SessionID int
+}
+
+// Insert records the sighting and updates the blob it refers to
+func (s *Sighting) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO Blob(sha256, size, first_seen, last_seen)
+		VALUES($1, $2, $3, $3)
+		ON CONFLICT (sha256) DO UPDATE SET
+			first_seen = LEAST(Blob.first_seen, EXCLUDED.first_seen),
+			last_seen = GREATEST(Blob.last_seen, EXCLUDED.last_seen),
+			times_seen = Blob.times_seen + 1
+`, s.SHA256, s.Size, s.TS)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO BlobSighting(sha256, session_id, source, path, ts)
+		VALUES($1, $2, $3, $4, $5)
+`, s.SHA256, sessionID, s.Source, db.Sanitize(s.Path), s.TS)
+	return err
+}
+
+// Info is everything known about a blob
+type Info struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	SHA256    string
+	Sightings []Sighting
+	Size      int64
+	TimesSeen int
+}
+
+// Lookup returns everything known about the blob with the given hash
+func Lookup(tx pgx.Tx, hash string) (Info, error) {
+	if !ValidHash(hash) {
+		return Info{}, ErrInvalidHash
+	}
+	info := Info{SHA256: strings.ToLower(hash)}
+
+	err := tx.QueryRow(context.TODO(), `
+	SELECT size, first_seen, last_seen, times_seen FROM Blob WHERE sha256 = $1
+`, info.SHA256).Scan(&info.Size, &info.FirstSeen, &info.LastSeen, &info.TimesSeen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+
+	rows, err := tx.Query(context.TODO(), `
+	SELECT session_id, source, path, ts FROM BlobSighting WHERE sha256 = $1 ORDER BY ts
+`, info.SHA256)
+	if err != nil {
+		return Info{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := Sighting{SHA256: info.SHA256, Size: info.Size}
+		if err = rows.Scan(&s.SessionID, &s.Source, &s.Path, &s.TS); err != nil {
+			return Info{}, err
+		}
+		info.Sightings = append(info.Sightings, s)
+	}
+	return info, rows.Err()
+}
@@ -0,0 +1,97 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidHash is returned when looking up something that is not a SHA256 hash
+var ErrInvalidHash = errors.New("invalid SHA256 hash")
+
+// Store is a content-addressed store of artifacts.
+// Each blob is identified by the SHA256 hash of its content
+// and stored only once no matter how often it is seen.
+type Store interface {
+	// Put stores the data unless it is already present
+	// and returns its hex encoded SHA256 hash
+	Put(data []byte) (string, error)
+	// Get returns the data with the given hash
+	Get(hash string) ([]byte, error)
+}
+
+// FSStore is a Store on the local filesystem
+type FSStore struct {
+	dir string
+}
+
+// NewFSStore creates a new store in the given directory
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FSStore{dir: dir}, nil
+}
+
+// Hash returns the hex encoded SHA256 hash of the data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put implements the Store interface
+func (s *FSStore) Put(data []byte) (string, error) {
+	hash := Hash(data)
+
+	path := s.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first so that
+	// no partially written blob is ever visible
+	f, err := os.CreateTemp(filepath.Dir(path), hash+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		// nolint:errcheck // nothing to do about it
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Get implements the Store interface
+func (s *FSStore) Get(hash string) ([]byte, error) {
+	if !ValidHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	return os.ReadFile(s.path(strings.ToLower(hash)))
+}
+
+// ValidHash reports whether hash is a hex encoded SHA256 hash
+func ValidHash(hash string) bool {
+	b, err := hex.DecodeString(hash)
+	return err == nil && len(b) == sha256.Size
+}
+
+// path returns where the blob with the given hash is stored
+func (s *FSStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
@@ -0,0 +1,163 @@
+// Package asciicast converts recorded terminal sessions to asciicast v2
+// (https://docs.asciinema.org/manual/asciicast/v2/) so that they can be replayed
+package asciicast
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Version is the version of the asciicast format
+const Version = 2
+
+// Size of the terminal if the client never requested a pty
+const (
+	DefaultWidth  = 80
+	DefaultHeight = 24
+)
+
+// Event types
+const (
+	Output = "o"
+	Input  = "i"
+	Resize = "r"
+)
+
+// Header is the first line of an asciicast
+type Header struct {
+	Env       map[string]string `json:"env,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+}
+
+// Event is something that happened in the terminal
+type Event struct {
+	Type string
+	Data string
+	Time float64 // Seconds since the start of the recording
+}
+
+// MarshalJSON encodes the event as an array as required by the format
+func (e Event) MarshalJSON() ([]byte, error) {
+	// Microsecond precision is plenty and keeps the files small
+	t := json.Number(strconv.FormatFloat(math.Round(e.Time*1e6)/1e6, 'f', -1, 64))
+	return json.Marshal([]any{t, e.Type, e.Data})
+}
+
+// Cast is an asciicast recording
+type Cast struct {
+	Events []Event
+	Header Header
+}
+
+// Size is the size of a terminal from a point in time
+type Size struct {
+	TS     time.Time
+	Width  int
+	Height int
+}
+
+// String returns the size as the data of a resize event
+func (s Size) String() string {
+	return strconv.Itoa(s.Width) + "x" + strconv.Itoa(s.Height)
+}
+
+// newCast creates a cast of a terminal of the given size, and term if known
+func newCast(start time.Time, size Size, term, title string) *Cast {
+	if size.Width <= 0 || size.Height <= 0 {
+		size.Width, size.Height = DefaultWidth, DefaultHeight
+	}
+	c := &Cast{Header: Header{
+		Title:     title,
+		Version:   Version,
+		Width:     size.Width,
+		Height:    size.Height,
+		Timestamp: start.Unix(),
+	}}
+	if term != "" {
+		c.Header.Env = map[string]string{"TERM": term}
+	}
+	return c
+}
+
+// Encode writes the cast to w in the asciicast v2 format
+func (c *Cast) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(c.Header); err != nil {
+		return err
+	}
+	for _, e := range c.Events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Duration returns the time of the last event
+func (c *Cast) Duration() time.Duration {
+	if len(c.Events) == 0 {
+		return 0
+	}
+	return time.Duration(c.Events[len(c.Events)-1].Time * float64(time.Second))
+}
+
+// addResizes adds resize events for the sizes, relative to start
+func (c *Cast) addResizes(start time.Time, resizes []Size) {
+	for _, r := range resizes {
+		if r.Width <= 0 || r.Height <= 0 {
+			continue
+		}
+		t := r.TS.Sub(start).Seconds()
+		if t < 0 {
+			t = 0
+		}
+		c.Events = append(c.Events, Event{Type: Resize, Data: r.String(), Time: t})
+	}
+	sort.SliceStable(c.Events, func(i, j int) bool { return c.Events[i].Time < c.Events[j].Time })
+}
+
+// decoder turns a stream of bytes into valid UTF-8 strings
+// without splitting characters between events
+type decoder struct {
+	pending []byte
+}
+
+// decode returns the complete characters of p and
+// whatever was left over from the previous call
+func (d *decoder) decode(p []byte) string {
+	b := append(d.pending, p...)
+	n := incompleteSuffix(b)
+	d.pending = append([]byte(nil), b[len(b)-n:]...)
+	return strings.ToValidUTF8(string(b[:len(b)-n]), "\uFFFD")
+}
+
+// flush returns what is left over
+func (d *decoder) flush() string {
+	s := strings.ToValidUTF8(string(d.pending), "\uFFFD")
+	d.pending = nil
+	return s
+}
+
+// incompleteSuffix returns the length of the character b ends with
+// if it is missing bytes
+func incompleteSuffix(b []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package asciicast
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds float64) time.Time {
+	return start.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+func TestFromChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []Chunk
+		size    Size
+		resizes []Size
+		input   bool
+		header  Header
+		events  []Event
+	}{
+		{
+			name: "output only",
+			chunks: []Chunk{
+				{TS: at(0.5), Data: []byte("$ ")},
+				{TS: at(1), Data: []byte("id\r\n"), FromClient: true},
+				{TS: at(1.25), Data: []byte("uid=0(root)\r\n")},
+			},
+			size:   Size{Width: 120, Height: 40},
+			header: Header{Version: 2, Width: 120, Height: 40, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{{Type: Output, Data: "$ ", Time: 0.5}, {Type: Output, Data: "uid=0(root)\r\n", Time: 1.25}},
+		},
+		{
+			name: "with input",
+			chunks: []Chunk{
+				{TS: at(0.5), Data: []byte("$ ")},
+				{TS: at(1), Data: []byte("id\r"), FromClient: true},
+			},
+			input:  true,
+			header: Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{{Type: Output, Data: "$ ", Time: 0.5}, {Type: Input, Data: "id\r", Time: 1}},
+		},
+		{
+			name: "characters split across chunks",
+			chunks: []Chunk{
+				{TS: at(0.5), Data: []byte("caf\xc3")},
+				{TS: at(1), Data: []byte("\xa9 \xe2\x82")},
+				{TS: at(1.5), Data: []byte("\xac")},
+			},
+			header: Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{{Type: Output, Data: "caf", Time: 0.5}, {Type: Output, Data: "é ", Time: 1}, {Type: Output, Data: "€", Time: 1.5}},
+		},
+		{
+			name: "invalid and incomplete UTF-8",
+			chunks: []Chunk{
+				{TS: at(0.5), Data: []byte("a\xffb")},
+				{TS: at(1), Data: []byte("c\xe2\x82")},
+			},
+			header: Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{{Type: Output, Data: "a�b", Time: 0.5}, {Type: Output, Data: "c", Time: 1}, {Type: Output, Data: "�", Time: 1}},
+		},
+		{
+			name: "timestamps going backwards",
+			chunks: []Chunk{
+				{TS: at(-1), Data: []byte("a")},
+				{TS: at(2), Data: []byte("b")},
+				{TS: at(1), Data: []byte("c")},
+			},
+			header: Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{{Type: Output, Data: "a", Time: 0}, {Type: Output, Data: "b", Time: 2}, {Type: Output, Data: "c", Time: 2}},
+		},
+		{
+			name: "resizes",
+			chunks: []Chunk{
+				{TS: at(0.5), Data: []byte("a")},
+				{TS: at(2), Data: []byte("b")},
+			},
+			resizes: []Size{{TS: at(1), Width: 100, Height: 30}, {TS: at(1.5), Width: 0, Height: 30}, {TS: at(-1), Width: 90, Height: 20}},
+			header:  Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix(), Env: map[string]string{"TERM": "xterm"}},
+			events: []Event{
+				{Type: Resize, Data: "90x20", Time: 0},
+				{Type: Output, Data: "a", Time: 0.5},
+				{Type: Resize, Data: "100x30", Time: 1},
+				{Type: Output, Data: "b", Time: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := FromChunks(tt.chunks, start, tt.size, "xterm", "", tt.resizes, tt.input)
+			if !reflect.DeepEqual(c.Header, tt.header) {
+				t.Errorf("got header %+v, want %+v", c.Header, tt.header)
+			}
+			if !reflect.DeepEqual(c.Events, tt.events) {
+				t.Errorf("got events %+v, want %+v", c.Events, tt.events)
+			}
+		})
+	}
+}
+
+func TestFromScript(t *testing.T) {
+	header := "Script started on 2024-01-01 12:00:00+00:00 [TERM=\"screen\" TTY=\"/dev/pts/0\" COLUMNS=\"132\" LINES=\"43\"]\n"
+	done := "\nScript done on 2024-01-01 12:01:00+00:00 [COMMAND_EXIT_CODE=\"0\"]\n"
+
+	tests := []struct {
+		name    string
+		output  string
+		timing  string
+		size    Size
+		wantErr string
+		header  Header
+		events  []Event
+	}{
+		{
+			name:   "header and markers",
+			output: header + "$ id\r\nuid=0\r\n" + done,
+			timing: "0.5 2\n1.25 11\n",
+			header: Header{Version: 2, Width: 132, Height: 43, Timestamp: start.Unix(), Env: map[string]string{"TERM": "screen"}},
+			events: []Event{{Type: Output, Data: "$ ", Time: 0.5}, {Type: Output, Data: "id\r\nuid=0\r\n", Time: 1.75}},
+		},
+		{
+			name:   "size of the pty wins over the header",
+			output: header + "$ ",
+			timing: "0.5 2\n",
+			size:   Size{Width: 80, Height: 25},
+			header: Header{Version: 2, Width: 80, Height: 25, Timestamp: start.Unix(), Env: map[string]string{"TERM": "screen"}},
+			events: []Event{{Type: Output, Data: "$ ", Time: 0.5}},
+		},
+		{
+			name:   "appended sessions",
+			output: header + "a" + done + header + "b" + done,
+			timing: "0.5 1\n\n0.5 1\n",
+			header: Header{Version: 2, Width: 132, Height: 43, Timestamp: start.Unix(), Env: map[string]string{"TERM": "screen"}},
+			events: []Event{{Type: Output, Data: "a", Time: 0.5}, {Type: Output, Data: "b", Time: 1}},
+		},
+		{
+			name:   "output cut short",
+			output: header + "abc",
+			timing: "0.5 2\n0.5 100\n0.5 1\n",
+			header: Header{Version: 2, Width: 132, Height: 43, Timestamp: start.Unix(), Env: map[string]string{"TERM": "screen"}},
+			events: []Event{{Type: Output, Data: "ab", Time: 0.5}, {Type: Output, Data: "c", Time: 1}},
+		},
+		{
+			name:   "no header",
+			output: "abc",
+			timing: "0.5 3\n",
+			header: Header{Version: 2, Width: DefaultWidth, Height: DefaultHeight, Timestamp: start.Unix()},
+			events: []Event{{Type: Output, Data: "abc", Time: 0.5}},
+		},
+		{
+			name:    "advanced timing format",
+			output:  header + "abc",
+			timing:  "O 0.5 3\n",
+			wantErr: "line 1 of timing: unsupported format",
+		},
+		{
+			name:    "invalid delay",
+			output:  header + "abc",
+			timing:  "0.5 1\nsoon 2\n",
+			wantErr: "line 2 of timing",
+		},
+		{
+			name:    "negative length",
+			output:  header + "abc",
+			timing:  "0.5 -1\n",
+			wantErr: `line 1 of timing: invalid length "-1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := FromScript(tt.output, tt.timing, start, tt.size, "", "", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Header, tt.header) {
+				t.Errorf("got header %+v, want %+v", c.Header, tt.header)
+			}
+			if !reflect.DeepEqual(c.Events, tt.events) {
+				t.Errorf("got events %+v, want %+v", c.Events, tt.events)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	c := newCast(start, Size{Width: 100, Height: 30}, "xterm", "botpot")
+	c.Events = []Event{
+		{Type: Output, Data: "\x1b[1mhi\x1b[0m\r\n", Time: 0.1234567},
+		{Type: Resize, Data: "90x20", Time: 2},
+	}
+
+	buf := bytes.Buffer{}
+	if err := c.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"env":{"TERM":"xterm"},"title":"botpot","version":2,"width":100,"height":30,"timestamp":1704110400}
+[0.123457,"o","\u001b[1mhi\u001b[0m\r\n"]
+[2,"r","90x20"]
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if d := c.Duration(); d != 2*time.Second {
+		t.Errorf("got duration %s, want 2s", d)
+	}
+}
@@ -0,0 +1,47 @@
+package asciicast
+
+import (
+	"time"
+)
+
+// Chunk is a piece of data sent over a channel
+type Chunk struct {
+	TS         time.Time
+	Data       []byte
+	FromClient bool
+}
+
+// FromChunks converts the data of a channel to a cast. The data sent by the
+// client is included as input events if input is true. Chunks must be in order.
+func FromChunks(chunks []Chunk, start time.Time, size Size, term, title string, resizes []Size, input bool) *Cast {
+	c := newCast(start, size, term, title)
+
+	// Keep the directions apart so that characters
+	// split across chunks can be put back together
+	out, in := decoder{}, decoder{}
+	last := 0.0
+	for _, chunk := range chunks {
+		t := chunk.TS.Sub(start).Seconds()
+		if t < last {
+			t = last
+		}
+		last = t
+
+		if chunk.FromClient {
+			if data := in.decode(chunk.Data); input && data != "" {
+				c.Events = append(c.Events, Event{Type: Input, Data: data, Time: t})
+			}
+		} else if data := out.decode(chunk.Data); data != "" {
+			c.Events = append(c.Events, Event{Type: Output, Data: data, Time: t})
+		}
+	}
+	if data := out.flush(); data != "" {
+		c.Events = append(c.Events, Event{Type: Output, Data: data, Time: last})
+	}
+	if data := in.flush(); input && data != "" {
+		c.Events = append(c.Events, Event{Type: Input, Data: data, Time: last})
+	}
+
+	c.addResizes(start, resizes)
+	return c
+}
@@ -0,0 +1,133 @@
+package asciicast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoRecording is returned when there is nothing to convert
+var ErrNoRecording = errors.New("no recording found")
+
+// pty is the terminal requested on a channel
+type pty struct {
+	ts      time.Time
+	term    string
+	size    Size
+	resizes []Size
+	channel uint32
+}
+
+// LoadScript loads the output recorded by script(1) in the host of a session
+func LoadScript(tx pgx.Tx, sessionID int) (*Cast, error) {
+	var start time.Time
+	var output, timing string
+	err := tx.QueryRow(context.TODO(), `
+	SELECT start_ts, stdout, timing FROM Session WHERE id = $1
+`, sessionID).Scan(&start, &output, &timing)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && timing == "") {
+		return nil, ErrNoRecording
+	} else if err != nil {
+		return nil, err
+	}
+
+	// script is started by the login shell, so it
+	// is recording the channel a shell was requested on
+	p, err := loadPTY(tx, sessionID, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(context.TODO(), `
+	SELECT ts FROM Request
+		WHERE session_id = $1 AND channel_id = $2 AND type = 'shell' AND from_client
+		ORDER BY ts LIMIT 1
+`, sessionID, p.channel).Scan(&start)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// The size is taken from the output if the client never requested a pty
+	return FromScript(output, timing, start, p.size, p.term, fmt.Sprintf("botpot session %d", sessionID), p.resizes)
+}
+
+// LoadChannel loads the data of a channel of a session. If channelID
+// is 0 the first channel the client requested a pty on is loaded.
+// The data sent by the client is included if input is true.
+func LoadChannel(tx pgx.Tx, sessionID int, channelID uint32, input bool) (*Cast, error) {
+	p, err := loadPTY(tx, sessionID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if channelID == 0 {
+		channelID = p.channel
+	}
+
+	var start time.Time
+	err = tx.QueryRow(context.TODO(), `
+	SELECT start_ts FROM Channel WHERE session_id = $1 AND id = $2
+`, sessionID, channelID).Scan(&start)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoRecording
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(context.TODO(), `
+	SELECT ts, from_client, data FROM ChannelData
+		WHERE session_id = $1 AND channel_id = $2
+		ORDER BY ts, id
+`, sessionID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Chunk, error) {
+		var c Chunk
+		err := row.Scan(&c.TS, &c.FromClient, &c.Data)
+		return c, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("botpot session %d channel %d", sessionID, channelID)
+	return FromChunks(chunks, start, p.size, p.term, title, p.resizes, input), nil
+}
+
+// loadPTY loads the first pty the client requested on the channel, or in the
+// whole session if channelID is 0, and how the window was resized afterwards.
+// A zero pty is returned if none was requested.
+func loadPTY(tx pgx.Tx, sessionID int, channelID uint32) (pty, error) {
+	var p pty
+	var columns, rows int
+	err := tx.QueryRow(context.TODO(), `
+	SELECT r.ts, r.channel_id, p.term, p.columns, p.rows FROM Request r
+		JOIN PTYRequest p ON p.request_id = r.id
+		WHERE r.session_id = $1 AND ($2 = 0 OR r.channel_id = $2) AND r.from_client
+		ORDER BY r.ts LIMIT 1
+`, sessionID, int64(channelID)).Scan(&p.ts, &p.channel, &p.term, &columns, &rows)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pty{channel: channelID}, nil
+	} else if err != nil {
+		return p, err
+	}
+	p.size = Size{TS: p.ts, Width: columns, Height: rows}
+
+	res, err := tx.Query(context.TODO(), `
+	SELECT r.ts, w.columns, w.rows FROM Request r
+		JOIN WindowDimChangeRequest w ON w.request_id = r.id
+		WHERE r.session_id = $1 AND r.channel_id = $2 AND r.from_client
+		ORDER BY r.ts
+`, sessionID, p.channel)
+	if err != nil {
+		return p, err
+	}
+	p.resizes, err = pgx.CollectRows(res, func(row pgx.CollectableRow) (Size, error) {
+		var s Size
+		err := row.Scan(&s.TS, &s.Width, &s.Height)
+		return s, err
+	})
+	return p, err
+}
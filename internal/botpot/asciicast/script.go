@@ -0,0 +1,97 @@
+package asciicast
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markers script writes to the output file outside of the timed data
+const (
+	scriptStarted = "Script started on "
+	scriptDone    = "Script done on "
+)
+
+var scriptHeaderVar = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// FromScript converts the output and timing files written by script(1) in the
+// classic format to a cast. The size of the terminal is taken from the header
+// of the output if size is zero. The resizes are relative to start, which
+// should be when script was started.
+func FromScript(output, timing string, start time.Time, size Size, term, title string, resizes []Size) (*Cast, error) {
+	header, pos := skipScriptMarkers(output, 0)
+	vars := map[string]string{}
+	for _, m := range scriptHeaderVar.FindAllStringSubmatch(header, -1) {
+		vars[m[1]] = m[2]
+	}
+	if size.Width <= 0 || size.Height <= 0 {
+		size.Width, _ = strconv.Atoi(vars["COLUMNS"])
+		size.Height, _ = strconv.Atoi(vars["LINES"])
+	}
+	if term == "" {
+		term = vars["TERM"]
+	}
+
+	c := newCast(start, size, term, title)
+	d := decoder{}
+	t := 0.0
+	s := bufio.NewScanner(strings.NewReader(timing))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d of timing: unsupported format", line)
+		}
+		delay, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of timing: %w", line, err)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("line %d of timing: invalid length %q", line, fields[1])
+		}
+
+		// Sessions appended to the same file are separated by markers
+		_, pos = skipScriptMarkers(output, pos)
+		if pos+n > len(output) {
+			n = len(output) - pos // Output was cut short
+		}
+		t += delay
+		if data := d.decode([]byte(output[pos : pos+n])); data != "" {
+			c.Events = append(c.Events, Event{Type: Output, Data: data, Time: t})
+		}
+		pos += n
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if data := d.flush(); data != "" {
+		c.Events = append(c.Events, Event{Type: Output, Data: data, Time: t})
+	}
+
+	c.addResizes(start, resizes)
+	return c, nil
+}
+
+// skipScriptMarkers skips the lines script writes when starting and stopping
+// at pos, returning the last line skipped and the position after them
+func skipScriptMarkers(output string, pos int) (string, int) {
+	last := ""
+	for {
+		rest := strings.TrimPrefix(output[pos:], "\n")
+		if !strings.HasPrefix(rest, scriptStarted) && !strings.HasPrefix(rest, scriptDone) {
+			return last, pos
+		}
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		last = rest[:end+1]
+		pos = len(output) - len(rest) + end + 1
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/Netflix/go-env"
@@ -8,16 +9,47 @@ import (
 
 // Config holds all the config needed for the application
 type Config struct {
-	LogLevel          string `env:"LOG_LEVEL"`
-	PGHost            string `env:"PG_HOST"`
-	DockerHost        string `env:"DOCKER_HOST"`
-	DockerNetwork     string `env:"DOCKER_NETWORK_NAME"`
-	HoneypotImage     string `env:"HONEYPOT_IMAGE"`
-	SSHHostKeysString string `env:"SSH_HOST_KEYS"`
-	SSHServerVersion  string `env:"SSH_SERVER_VERSION"`
-	SSHHostKeys       []string
-	Port              int `env:"PORT"`
-	HostBuffer        int `env:"HOST_BUFFER"`
+	LogLevel            string `env:"LOG_LEVEL"`
+	PGHost              string `env:"PG_HOST"`
+	DockerHost          string `env:"DOCKER_HOST"`
+	DockerNetwork       string `env:"DOCKER_NETWORK_NAME"`
+	HoneypotImage       string `env:"HONEYPOT_IMAGE"`
+	SSHHostKeysString   string `env:"SSH_HOST_KEYS"`
+	SSHServerVersion    string `env:"SSH_SERVER_VERSION"`
+	AuthWordlist        string `env:"AUTH_WORDLIST"`
+	AuthDenyUsersStr    string `env:"AUTH_DENY_USERS"`
+	CaptureSpillDir     string `env:"CAPTURE_SPILL_DIR"`
+	ArtifactDir         string `env:"ARTIFACT_DIR,default=artifacts"`
+	ForwardRulesStr     string `env:"FORWARD_RULES"`
+	SMTPHostname        string `env:"SINKHOLE_SMTP_HOSTNAME,default=mail.localdomain"`
+	HTTPBody            string `env:"SINKHOLE_HTTP_BODY"`
+	HTTPContentType     string `env:"SINKHOLE_HTTP_CONTENT_TYPE,default=text/html; charset=utf-8"`
+	EventSinksStr       string `env:"EVENT_SINKS,default=postgres"`
+	EventJSONLFile      string `env:"EVENT_JSONL_FILE"`
+	EventSyslogNetwork  string `env:"EVENT_SYSLOG_NETWORK"`
+	EventSyslogAddr     string `env:"EVENT_SYSLOG_ADDR"`
+	EventWebhookURL     string `env:"EVENT_WEBHOOK_URL"`
+	EventWebhookAuth    string `env:"EVENT_WEBHOOK_AUTHORIZATION"`
+	HTTPAddr            string `env:"HTTP_ADDR"`
+	OperatorsStr        string `env:"OPERATORS"`
+	AdminToken          string `env:"ADMIN_TOKEN"`
+	MetricsToken        string `env:"METRICS_TOKEN"`
+	SSHHostKeys         []string
+	AuthDenyUsers       []string
+	ForwardRules        []string
+	EventSinks          []string
+	Operators           map[string]string // Token of every operator by name
+	AuthProbability     float64           `env:"AUTH_ACCEPT_PROBABILITY,default=1"`
+	Port                int               `env:"PORT"`
+	HostBuffer          int               `env:"HOST_BUFFER"`
+	HTTPStatus          int               `env:"SINKHOLE_HTTP_STATUS,default=200"`
+	AuthAcceptAfter     int               `env:"AUTH_ACCEPT_AFTER"`
+	EventBuffer         int               `env:"EVENT_BUFFER,default=4096"`
+	EventWebhookTimeout int               `env:"EVENT_WEBHOOK_TIMEOUT,default=10"`
+	ChannelCaptureLimit int64             `env:"CHANNEL_CAPTURE_LIMIT,default=67108864"`
+	SessionCaptureLimit int64             `env:"SESSION_CAPTURE_LIMIT,default=268435456"`
+	ArtifactMaxSize     int64             `env:"ARTIFACT_MAX_SIZE,default=67108864"`
+	SMTPMaxSize         int64             `env:"SINKHOLE_SMTP_MAX_SIZE,default=10485760"`
 }
 
 // GetConfig returns the configuration
@@ -29,6 +61,36 @@ func GetConfig() (Config, error) {
 		return cfg, err
 	}
 	cfg.SSHHostKeys = strings.Split(cfg.SSHHostKeysString, ":")
+	if cfg.AuthDenyUsersStr != "" {
+		cfg.AuthDenyUsers = strings.Split(cfg.AuthDenyUsersStr, ",")
+	}
+	if cfg.ForwardRulesStr != "" {
+		cfg.ForwardRules = strings.Split(cfg.ForwardRulesStr, ",")
+	}
+	if cfg.EventSinksStr != "" {
+		cfg.EventSinks = strings.Split(cfg.EventSinksStr, ",")
+	}
+	if cfg.OperatorsStr != "" {
+		if cfg.Operators, err = parseOperators(cfg.OperatorsStr); err != nil {
+			return cfg, err
+		}
+	}
 
 	return cfg, err
 }
+
+// parseOperators parses operators of the form name:token separated by commas
+func parseOperators(s string) (map[string]string, error) {
+	operators := make(map[string]string)
+	for _, o := range strings.Split(s, ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(o), ":")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid operator %q, expected name:token", o)
+		}
+		if _, dup := operators[name]; dup {
+			return nil, fmt.Errorf("operator %q is defined twice", name)
+		}
+		operators[name] = token
+	}
+	return operators, nil
+}
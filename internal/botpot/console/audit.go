@@ -0,0 +1,47 @@
+package console
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Types of actions operators take
+const (
+	ActionWatch  = "watch"
+	ActionInject = "inject"
+	ActionKill   = "kill"
+)
+
+// Action is something an operator did to a live channel
+type Action struct {
+	TS         time.Time
+	Data       []byte // Injected data
+	Session    string
+	Operator   string
+	RemoteAddr string
+	Type       string
+	ChannelID  uint32
+}
+
+// Insert tries to insert the action into the database
+func (a *Action) Insert(tx pgx.Tx) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO OperatorAction(session_uid, channel_id, ts, operator, remote_addr, action, data)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+`, a.Session, a.ChannelID, a.TS, a.Operator, a.RemoteAddr, a.Type, a.Data)
+	return err
+}
+
+func (a *Action) eventData() map[string]any {
+	data := map[string]any{
+		"operator":    a.Operator,
+		"remote_addr": a.RemoteAddr,
+		"action":      a.Type,
+	}
+	if a.Data != nil {
+		data["data"] = a.Data
+	}
+	return data
+}
@@ -0,0 +1,233 @@
+// Package console lets operators watch live channels
+// and take them over by injecting data or killing them
+package console
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
+	"github.com/alx99/botpot/internal/botpot/ssh/channel"
+	"github.com/alx99/botpot/internal/botpot/web"
+	"github.com/rs/zerolog/log"
+)
+
+// maxInject is the max amount of bytes injected per request
+const maxInject = 64 * 1024
+
+var errUnknownChannel = errors.New("unknown channel")
+
+// Console keeps track of the live channels and
+// serves the endpoints operators use to act on them
+type Console struct {
+	db       db.Store
+	events   *event.Dispatcher
+	channels map[liveKey]*channel.Channel
+	mux      *http.ServeMux
+	mu       sync.Mutex
+}
+
+type liveKey struct {
+	session string
+	id      uint32
+}
+
+// LiveChannel describes a channel operators can act on
+type LiveChannel struct {
+	Started time.Time `json:"started"`
+	Session string    `json:"session"`
+	Type    string    `json:"type"`
+	ID      uint32    `json:"channel_id"`
+}
+
+// New creates a new console
+func New(database db.Store, events *event.Dispatcher) *Console {
+	c := &Console{
+		db:       database,
+		events:   events,
+		channels: make(map[liveKey]*channel.Channel),
+		mux:      http.NewServeMux(),
+	}
+	c.mux.HandleFunc("/console/channels", c.handleChannels)
+	c.mux.HandleFunc("/console/watch", c.handleWatch)
+	c.mux.HandleFunc("/console/inject", c.handleInject)
+	c.mux.HandleFunc("/console/kill", c.handleKill)
+	return c
+}
+
+// Add makes the channel of session available to operators until it has closed
+func (c *Console) Add(session string, ch *channel.Channel) {
+	key := liveKey{session: session, id: ch.ID()}
+	c.mu.Lock()
+	c.channels[key] = ch
+	c.mu.Unlock()
+
+	go func() {
+		ch.Wait()
+		c.mu.Lock()
+		delete(c.channels, key)
+		c.mu.Unlock()
+	}()
+}
+
+// Channels returns the live channels, oldest first
+func (c *Console) Channels() []LiveChannel {
+	c.mu.Lock()
+	res := make([]LiveChannel, 0, len(c.channels))
+	for key, ch := range c.channels {
+		res = append(res, LiveChannel{Started: ch.Started(), Session: key.session, Type: ch.Type(), ID: key.id})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+	return res
+}
+
+// ServeHTTP serves the console endpoints. Operators have to be authenticated
+// with web.RequireOperator so that their actions can be audited.
+func (c *Console) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if web.Operator(r.Context()) == "" {
+		http.Error(w, "unknown operator", http.StatusUnauthorized)
+		return
+	}
+	c.mux.ServeHTTP(w, r)
+}
+
+func (c *Console) handleChannels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// nolint:errcheck // nothing to do if the client is gone
+	json.NewEncoder(w).Encode(c.Channels())
+}
+
+// handleWatch streams what the client sees on the channel until it closes.
+// The data sent by the client is included if input is true.
+func (c *Console) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ch, err := c.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	watcher := ch.Watch(r.URL.Query().Get("input") == "true")
+	defer ch.Unwatch(watcher)
+	c.audit(r, key, ActionWatch, nil)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		data, err := watcher.Read(r.Context())
+		if errors.Is(err, channel.ErrWatcherBehind) {
+			// nolint:errcheck // the watch is over either way
+			io.WriteString(w, "\r\n[botpot: "+err.Error()+"]\r\n")
+		}
+		if err != nil {
+			return
+		}
+		if _, err = w.Write(data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleInject sends the body of the request to the client
+func (c *Console) handleInject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ch, err := c.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxInject))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.audit(r, key, ActionInject, data)
+	if err = ch.Inject(data); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleKill closes the channel on both ends
+func (c *Console) handleKill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ch, err := c.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	c.audit(r, key, ActionKill, nil)
+	if err = ch.Kill(); err != nil {
+		log.Err(err).Str("session", key.session).Uint32("chID", key.id).Msg("Error while killing channel")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup returns the live channel given by the session and channel query parameters
+func (c *Console) lookup(r *http.Request) (liveKey, *channel.Channel, error) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("channel"), 10, 32)
+	if err != nil {
+		return liveKey{}, nil, errUnknownChannel
+	}
+	key := liveKey{session: r.URL.Query().Get("session"), id: uint32(id)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.channels[key]
+	if !ok {
+		return liveKey{}, nil, errUnknownChannel
+	}
+	return key, ch, nil
+}
+
+// audit records an action taken by an operator
+func (c *Console) audit(r *http.Request, key liveKey, action string, data []byte) {
+	a := Action{
+		TS:         time.Now(),
+		Data:       data,
+		Session:    key.session,
+		Operator:   web.Operator(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+		Type:       action,
+		ChannelID:  key.id,
+	}
+	log.Warn().Str("operator", a.Operator).Str("remoteAddr", a.RemoteAddr).Str("action", a.Type).
+		Str("session", a.Session).Uint32("chID", a.ChannelID).Int("bytes", len(a.Data)).Msg("Operator action")
+
+	c.events.Emit(event.Event{TS: a.TS, Type: event.OperatorAction, Session: a.Session, ChannelID: a.ChannelID, Data: a.eventData()})
+	if err := c.db.BeginTx(a.Insert); err != nil {
+		log.Err(err).Msg("Could not insert operator action into DB")
+	}
+}
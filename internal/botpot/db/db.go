@@ -16,13 +16,24 @@ type DB struct {
 	url  string
 }
 
-// NewDB creates a new DB
+// NewDB creates a new DB. If url is empty the DB is disabled
+// and nothing is stored, which is useful when all events
+// are shipped to other sinks.
 func NewDB(url string) DB {
 	return DB{url: url}
 }
 
+// Enabled returns whether data is stored in the DB
+func (db *DB) Enabled() bool {
+	return db.url != ""
+}
+
 // Start connects to the DB
 func (db *DB) Start() error {
+	if !db.Enabled() {
+		log.Info().Msg("Database disabled")
+		return nil
+	}
 	log.Info().Msg("Starting Database")
 
 	cfg, err := pgxpool.ParseConfig(db.url)
@@ -49,11 +60,25 @@ func (db *DB) Start() error {
 
 // Stop disconnects from the DB
 func (db *DB) Stop() error {
+	if !db.Enabled() {
+		return nil
+	}
 	log.Info().Msg("Stopping Database")
 	db.pool.Close()
 	return nil
 }
 
+// BeginTx runs f in a transaction. f is not run if the DB is disabled.
 func (db *DB) BeginTx(f func(pgx.Tx) error) error {
-	return pgx.BeginTxFunc(context.Background(), db.pool, pgx.TxOptions{AccessMode: pgx.ReadWrite}, f)
+	if !db.Enabled() {
+		return nil
+	}
+	t := time.Now()
+	err := pgx.BeginTxFunc(context.Background(), db.pool, pgx.TxOptions{AccessMode: pgx.ReadWrite}, f)
+	transactions.Inc()
+	transactionSeconds.Observe(time.Since(t).Seconds())
+	if err != nil {
+		transactionErrors.Inc()
+	}
+	return err
 }
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/alx99/botpot/internal/botpot/event"
+	"github.com/jackc/pgx/v5"
+)
+
+// Store stores everything captured during sessions. It is the postgres event
+// sink, the rest of the data is written in transactions as sessions go on.
+type Store interface {
+	event.Sink
+	// Enabled returns whether data is stored
+	Enabled() bool
+	// BeginTx runs f in a transaction, f is not run if the store is disabled
+	BeginTx(f func(pgx.Tx) error) error
+}
+
+var _ Store = (*DB)(nil)
+
+// Write stores the event, making the DB usable as an event sink.
+// Data events are skipped since the data is already stored per channel.
+func (db *DB) Write(e event.Event) error {
+	if e.Type == event.Data {
+		return nil
+	}
+
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	data = SanitizeJSON(data)
+
+	return db.BeginTx(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.TODO(), `
+	INSERT INTO Event(session_uid, channel_id, ts, type, data)
+		VALUES($1, $2, $3, $4, $5)
+`, Sanitize(e.Session), nilIfZero(e.ChannelID), e.TS, string(e.Type), string(data))
+		return err
+	})
+}
+
+func nilIfZero(id uint32) *uint32 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
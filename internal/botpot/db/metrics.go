@@ -0,0 +1,23 @@
+package db
+
+import (
+	"github.com/alx99/botpot/internal/botpot/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	transactions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_db_transactions_total",
+		Help: "Transactions run against the database.",
+	})
+	transactionErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_db_transaction_failures_total",
+		Help: "Transactions that failed, losing the data they inserted.",
+	})
+	transactionSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botpot_db_transaction_seconds",
+		Help:    "Time taken by a transaction.",
+		Buckets: metrics.LatencyBuckets,
+	})
+)
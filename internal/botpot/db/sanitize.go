@@ -0,0 +1,27 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Sanitize makes sure that the string can be stored as text,
+// which can hold neither NUL characters nor invalid UTF-8
+func Sanitize(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "\uFFFD")
+}
+
+// SanitizeAll sanitizes every string of s
+func SanitizeAll(s []string) []string {
+	res := make([]string, len(s))
+	for i := range s {
+		res[i] = Sanitize(s[i])
+	}
+	return res
+}
+
+// SanitizeJSON makes sure that the encoded JSON can be stored as JSONB,
+// which can not hold NUL characters
+func SanitizeJSON(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte(`\u0000`), []byte(`\ufffd`))
+}
@@ -0,0 +1,98 @@
+package event
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var dropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "botpot_events_dropped_total",
+	Help: "Events dropped because a sink fell too far behind.",
+})
+
+// Dispatcher fans events out to sinks. Every sink is written to from its
+// own goroutine so that a slow sink does not slow down sessions, events
+// are dropped if a sink falls too far behind.
+type Dispatcher struct {
+	queues []chan Event
+	sinks  []Sink
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewDispatcher creates a dispatcher buffering up to buffer events per sink
+func NewDispatcher(buffer int, sinks ...Sink) *Dispatcher {
+	d := &Dispatcher{sinks: sinks}
+	for range sinks {
+		d.queues = append(d.queues, make(chan Event, buffer))
+	}
+	return d
+}
+
+// Start starts all sinks
+func (d *Dispatcher) Start() error {
+	for i, s := range d.sinks {
+		if err := s.Start(); err != nil {
+			for _, started := range d.sinks[:i] {
+				// nolint:errcheck // the start error is what matters
+				started.Stop()
+			}
+			return err
+		}
+	}
+
+	d.wg.Add(len(d.sinks))
+	for i := range d.sinks {
+		go d.loop(d.sinks[i], d.queues[i])
+	}
+	return nil
+}
+
+// Stop writes the queued events and stops all sinks
+func (d *Dispatcher) Stop() error {
+	d.mu.Lock()
+	d.closed = true
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+
+	var err error
+	for _, s := range d.sinks {
+		err = errors.Join(err, s.Stop())
+	}
+	return err
+}
+
+// Emit queues an event for all sinks
+func (d *Dispatcher) Emit(e Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	for i, q := range d.queues {
+		select {
+		case q <- e:
+		default:
+			dropped.Inc()
+			log.Warn().Int("sink", i).Str("type", string(e.Type)).Msg("Event sink is falling behind, dropping event")
+		}
+	}
+}
+
+func (d *Dispatcher) loop(s Sink, q <-chan Event) {
+	defer d.wg.Done()
+	for e := range q {
+		if err := s.Write(e); err != nil {
+			log.Err(err).Str("type", string(e.Type)).Msg("Could not write event")
+		}
+	}
+}
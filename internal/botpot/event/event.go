@@ -0,0 +1,81 @@
+// Package event provides the events emitted while attackers are
+// connected and the sinks they can be shipped to
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Type is the type of an event
+type Type string
+
+// The types of events emitted during a session
+const (
+	Connect       Type = "connect"
+	AuthAttempt   Type = "auth_attempt"
+	ChannelOpen   Type = "channel_open"
+	ChannelClose  Type = "channel_close"
+	Request       Type = "request"
+	GlobalRequest Type = "global_request"
+	Data          Type = "data"
+	Disconnect    Type = "disconnect"
+	// OperatorAction is emitted when an operator acts on a live channel
+	OperatorAction Type = "operator_action"
+)
+
+// Event is something that happened during a session
+type Event struct {
+	TS        time.Time      `json:"ts"`
+	Data      map[string]any `json:"data,omitempty"`
+	Type      Type           `json:"type"`
+	Session   string         `json:"session"`
+	ChannelID uint32         `json:"channel_id,omitempty"` // 0 for events not tied to a channel
+}
+
+// Sink is somewhere events are shipped to
+type Sink interface {
+	Start() error
+	Stop() error
+	Write(e Event) error
+}
+
+// NewSessionID returns a random ID identifying a session across all sinks
+func NewSessionID() string {
+	b := make([]byte, 16)
+	// crypto/rand never fails on the platforms we run on
+	// nolint:errcheck
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Emitter emits the events of a single session
+type Emitter struct {
+	d       *Dispatcher
+	session string
+}
+
+// NewEmitter creates an emitter emitting the events of session to d.
+// A nil dispatcher discards all events.
+func NewEmitter(d *Dispatcher, session string) Emitter {
+	return Emitter{d: d, session: session}
+}
+
+// Session returns the ID of the session
+func (e Emitter) Session() string {
+	return e.session
+}
+
+// Emit emits an event which is not tied to a channel if channelID is 0
+func (e Emitter) Emit(t Type, channelID uint32, data map[string]any) {
+	e.EmitAt(time.Now(), t, channelID, data)
+}
+
+// EmitAt emits an event that happened at ts
+func (e Emitter) EmitAt(ts time.Time, t Type, channelID uint32, data map[string]any) {
+	if e.d == nil {
+		return
+	}
+	e.d.Emit(Event{TS: ts, Type: t, Session: e.session, ChannelID: channelID, Data: data})
+}
@@ -0,0 +1,46 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// JSONL writes events to a file, one JSON object per line
+type JSONL struct {
+	f    *os.File
+	path string
+	mu   sync.Mutex
+}
+
+// NewJSONL creates a sink appending events to the file at path
+func NewJSONL(path string) *JSONL {
+	return &JSONL{path: path}
+}
+
+// Start opens the file
+func (j *JSONL) Start() error {
+	var err error
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	return err
+}
+
+// Stop closes the file
+func (j *JSONL) Stop() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// Write appends the event to the file
+func (j *JSONL) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(b, '\n'))
+	return err
+}
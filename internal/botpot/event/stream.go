@@ -0,0 +1,111 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// streamBuffer is how many events a subscriber may fall behind before events are dropped
+const streamBuffer = 256
+
+// Stream streams events live to HTTP clients as server-sent events.
+// Clients can limit the stream to one session with the session query parameter.
+type Stream struct {
+	subscribers map[chan Event]string
+	mu          sync.Mutex
+}
+
+// NewStream creates a new stream
+func NewStream() *Stream {
+	return &Stream{subscribers: make(map[chan Event]string)}
+}
+
+// Start does nothing, clients subscribe by connecting
+func (s *Stream) Start() error {
+	return nil
+}
+
+// Stop disconnects all subscribers
+func (s *Stream) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.subscribers {
+		close(c)
+		delete(s.subscribers, c)
+	}
+	return nil
+}
+
+// Write sends the event to all subscribers interested in it
+func (s *Stream) Write(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, session := range s.subscribers {
+		if session != "" && session != e.Session {
+			continue
+		}
+		select {
+		case c <- e:
+		default: // Slow subscriber, it will miss the event
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving the events of session, or all
+// events if session is empty, until Unsubscribe is called
+func (s *Stream) Subscribe(session string) chan Event {
+	c := make(chan Event, streamBuffer)
+	s.mu.Lock()
+	s.subscribers[c] = session
+	s.mu.Unlock()
+	return c
+}
+
+// Unsubscribe stops sending events to c
+func (s *Stream) Unsubscribe(c chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[c]; ok {
+		close(c)
+		delete(s.subscribers, c)
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := s.Subscribe(r.URL.Query().Get("session"))
+	defer s.Unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
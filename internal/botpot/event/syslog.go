@@ -0,0 +1,41 @@
+package event
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// Syslog writes events as JSON messages to syslog
+type Syslog struct {
+	w       *syslog.Writer
+	network string
+	addr    string
+	tag     string
+}
+
+// NewSyslog creates a sink writing to the syslog server at addr.
+// The local syslog server is used if network and addr are empty.
+func NewSyslog(network, addr, tag string) *Syslog {
+	return &Syslog{network: network, addr: addr, tag: tag}
+}
+
+// Start connects to the syslog server
+func (s *Syslog) Start() error {
+	var err error
+	s.w, err = syslog.Dial(s.network, s.addr, syslog.LOG_INFO|syslog.LOG_DAEMON, s.tag)
+	return err
+}
+
+// Stop disconnects from the syslog server
+func (s *Syslog) Stop() error {
+	return s.w.Close()
+}
+
+// Write sends the event to the syslog server
+func (s *Syslog) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(b))
+}
@@ -0,0 +1,69 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook POSTs every event as JSON to a URL
+type Webhook struct {
+	client  *http.Client
+	url     string
+	headers http.Header
+}
+
+// NewWebhook creates a sink posting events to url with the given
+// extra headers, giving up on a request after timeout
+func NewWebhook(url string, headers http.Header, timeout time.Duration) *Webhook {
+	return &Webhook{
+		client:  &http.Client{Timeout: timeout},
+		url:     url,
+		headers: headers,
+	}
+}
+
+// Start does nothing, every event is sent in its own request
+func (w *Webhook) Start() error {
+	return nil
+}
+
+// Stop closes idle connections to the webhook
+func (w *Webhook) Stop() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// Write posts the event to the webhook
+func (w *Webhook) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// nolint:errcheck // drained to reuse the connection
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
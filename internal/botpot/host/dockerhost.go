@@ -2,19 +2,23 @@ package host
 
 import (
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
 type DHost struct {
+	created  time.Time
+	baseline map[string]time.Time
 	id       string
+	image    string
 	running  bool
 	occupied bool
 	sync.RWMutex
 }
 
-func NewDHost(id string) *DHost {
-	return &DHost{id: id}
+func NewDHost(id, image string) *DHost {
+	return &DHost{id: id, image: image, created: time.Now()}
 }
 
 func (h *DHost) SetRunning(val bool) {
@@ -45,3 +49,23 @@ func (h *DHost) Occupied() bool {
 func (h *DHost) ID() string {
 	return h.id
 }
+
+func (h *DHost) Image() string {
+	return h.image
+}
+
+func (h *DHost) Created() time.Time {
+	return h.created
+}
+
+func (h *DHost) SetBaseline(baseline map[string]time.Time) {
+	h.Lock()
+	h.baseline = baseline
+	h.Unlock()
+}
+
+func (h *DHost) Baseline() map[string]time.Time {
+	h.RLock()
+	defer h.RUnlock()
+	return h.baseline
+}
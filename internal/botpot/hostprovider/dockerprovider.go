@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,10 +19,29 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/rs/zerolog/log"
 )
 
+// maxScriptOutput is the max size of the files script records a session in
+const maxScriptOutput = 64 << 20
+
+// validUser matches the usernames that can be created on a host,
+// which are the ones accepted by the adduser of busybox
+var validUser = regexp.MustCompile(`^[A-Za-z0-9_.@][A-Za-z0-9_.@-]{0,31}$`)
+
+// ignoredArtifacts are files changed by botpot itself
+var ignoredArtifacts = map[string]bool{
+	"/tmp/l": true,
+	"/tmp/t": true,
+}
+
+// imageCheck fails unless users can be created on a host
+// and their sessions are recorded by script
+const imageCheck = `test -x /bin/createuser && grep -q "^script .*/tmp/l -T /tmp/t" /etc/profile`
+
 // DockerProvider provides docker containers that
 // run SSH servers that can serve attackers
 type DockerProvider struct {
@@ -34,10 +55,11 @@ type DockerProvider struct {
 	host          string
 	config        container.Config
 	hostBuffer    int
+	maxArtifact   int64
 }
 
 // NewDockerProvider creates a new docker provider
-func NewDockerProvider(hostt string, config container.Config, hostConfig container.HostConfig, networkConfig network.NetworkingConfig, platform specs.Platform, hostBuffer int) *DockerProvider {
+func NewDockerProvider(hostt string, config container.Config, hostConfig container.HostConfig, networkConfig network.NetworkingConfig, platform specs.Platform, hostBuffer int, maxArtifact int64) *DockerProvider {
 	return &DockerProvider{
 		host:          hostt,
 		config:        config,
@@ -46,6 +68,7 @@ func NewDockerProvider(hostt string, config container.Config, hostConfig contain
 		plaform:       platform,
 		containers:    make(map[string]*host.DHost),
 		hostBuffer:    hostBuffer,
+		maxArtifact:   maxArtifact,
 		shutdown:      make(chan any),
 	}
 }
@@ -56,40 +79,41 @@ func (d *DockerProvider) Start(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	if err = d.pullImage(ctx, d.config.Image); err != nil {
+		return err
+	}
+
+	go d.monitorHostBuf(context.TODO())
+	return nil
+}
+
+// pullImage pulls the image unless it is already present
+func (d *DockerProvider) pullImage(ctx context.Context, name string) (err error) {
 	list, err := d.client.ImageList(ctx, types.ImageListOptions{})
 	if err != nil {
 		return err
 	}
 
-	found := false
 	for _, image := range list {
 		for _, tag := range image.RepoTags {
-			found = tag == d.config.Image
-			if found {
-				break
+			if tag == name {
+				return nil
 			}
 		}
-		if found {
-			break
-		}
 	}
 
-	if !found { // Pull image
-		readCloser, err := d.client.ImagePull(ctx, d.config.Image, types.ImagePullOptions{})
-		if err != nil {
-			return err
-		}
-		defer func() {
-			err = errors.Join(err, readCloser.Close())
-		}()
-
-		// this needs to be handled for whatever reason
-		if _, err := io.Copy(os.Stdout, readCloser); err != nil {
-			log.Err(err).Msg("Error while copying output to stdout")
-		}
+	readCloser, err := d.client.ImagePull(ctx, name, types.ImagePullOptions{})
+	if err != nil {
+		return err
 	}
+	defer func() {
+		err = errors.Join(err, readCloser.Close())
+	}()
 
-	go d.monitorHostBuf(context.TODO())
+	// this needs to be handled for whatever reason
+	if _, err := io.Copy(os.Stdout, readCloser); err != nil {
+		log.Err(err).Msg("Error while copying output to stdout")
+	}
 	return nil
 }
 
@@ -100,22 +124,7 @@ func (d *DockerProvider) monitorHostBuf(ctx context.Context) {
 	for {
 		select {
 		case <-t.C:
-			occupiedCount := 0
-			d.RLock()
-			hostCount := len(d.containers)
-			for _, h := range d.containers {
-				if h.Occupied() {
-					occupiedCount++
-				}
-			}
-			d.RUnlock()
-
-			for i := 0; i < d.hostBuffer-(hostCount-occupiedCount); i++ {
-				_, err := d.createAndRunContainer(ctx)
-				if err != nil {
-					log.Err(err).Msg("Error while creating&running container")
-				}
-			}
+			d.balance(ctx)
 			t.Reset(tDur)
 
 		case <-d.shutdown:
@@ -126,23 +135,63 @@ func (d *DockerProvider) monitorHostBuf(ctx context.Context) {
 	}
 }
 
+// balance starts containers until hostBuffer idle containers are running,
+// and retires idle containers that are superfluous or run an outdated image
+func (d *DockerProvider) balance(ctx context.Context) {
+	idleCount, occupiedCount := 0, 0
+	retired := []string{}
+	d.Lock()
+	for ID, h := range d.containers {
+		switch {
+		case h.Occupied():
+			occupiedCount++
+		case h.Image() != d.config.Image || idleCount >= d.hostBuffer:
+			// Occupy it so that it is not handed out while being deleted
+			h.SetOccupied(true)
+			retired = append(retired, ID)
+		default:
+			idleCount++
+		}
+	}
+	missing := d.hostBuffer - idleCount
+	d.Unlock()
+	containers.WithLabelValues("idle").Set(float64(idleCount))
+	containers.WithLabelValues("occupied").Set(float64(occupiedCount))
+
+	for _, ID := range retired {
+		if err := d.deleteContainer(ctx, ID); err != nil {
+			log.Err(err).Str("id", ID).Msg("Error while retiring container")
+		}
+	}
+	for i := 0; i < missing; i++ {
+		_, err := d.createAndRunContainer(ctx)
+		if err != nil {
+			log.Err(err).Msg("Error while creating&running container")
+		}
+	}
+}
+
 func (d *DockerProvider) createAndRunContainer(ctx context.Context) (*host.DHost, error) {
 	d.Lock()
 	defer d.Unlock()
 	t := time.Now()
 	res, err := d.client.ContainerCreate(ctx, &d.config, &d.hostConfig, &d.networkConfig, &d.plaform, "")
 	if err != nil {
+		containerStartFailures.Inc()
 		return nil, err
 	}
 
-	h := host.NewDHost(res.ID)
+	h := host.NewDHost(res.ID, d.config.Image)
 	d.containers[res.ID] = h
 
 	err = d.client.ContainerStart(ctx, res.ID, types.ContainerStartOptions{})
 	if err != nil {
+		containerStartFailures.Inc()
 		return nil, err
 	}
 	h.SetRunning(true)
+	containerStarts.Inc()
+	containerStartSeconds.Observe(time.Since(t).Seconds())
 
 	log.Debug().
 		Str("timeSinceCreation", time.Since(t).String()).
@@ -220,30 +269,36 @@ func (d *DockerProvider) deleteContainer(ctx context.Context, id string) error {
 // GetHost returns an available host in the format IP:PORT
 // to connect to
 func (d *DockerProvider) GetHost(ctx context.Context) (string, string, error) {
+	t := time.Now()
 	var H *host.DHost
-	d.RLock()
+	d.Lock()
 	for _, h := range d.containers {
-		if h.Running() && !h.Occupied() {
+		if h.Running() && !h.Occupied() && h.Image() == d.config.Image {
 			H = h
 			break
 		}
 	}
-	d.RUnlock()
+	if H != nil {
+		H.SetOccupied(true)
+	}
+	d.Unlock()
 
 	// In case no available containers
 	if H == nil {
+		poolMisses.Inc()
 		var err error
 		H, err = d.createAndRunContainer(ctx)
 		if err != nil {
 			return "", "", err
 		}
+		H.SetOccupied(true)
 	}
 
 	res, err := d.client.ContainerInspect(ctx, H.ID())
 	if err != nil {
+		H.SetOccupied(false)
 		return "", "", err
 	}
-	H.SetOccupied(true)
 
 	// Obtain network name
 	networkName := ""
@@ -261,6 +316,8 @@ func (d *DockerProvider) GetHost(ctx context.Context) (string, string, error) {
 		return "", "", errors.New("could not find network name")
 	}
 
+	hostSeconds.Observe(time.Since(t).Seconds())
+
 	// TODO this has to be fixed not to always return localhost
 	// and not always assume that 22/tcp is the ssh port
 	return fmt.Sprintf("%s:22", endPointSettings.IPAddress), H.ID(), err
@@ -277,7 +334,7 @@ func (d *DockerProvider) GetScriptOutput(ctx context.Context, id string) (string
 		return "", "", err
 	}
 
-	stdout, err := readTar(r)
+	stdout, err := readTar(r, maxScriptOutput)
 	if err != nil {
 		return "", "", err
 	}
@@ -287,12 +344,150 @@ func (d *DockerProvider) GetScriptOutput(ctx context.Context, id string) (string
 		return "", "", err
 	}
 
-	timing, err := readTar(r)
+	timing, err := readTar(r, maxScriptOutput)
 	if err != nil {
 		return "", "", err
 	}
 
-	return stdout, timing, nil
+	return string(stdout), string(timing), nil
+}
+
+// CreateUser creates a user with the specified password on the host.
+// If the user already exists only its password is changed.
+func (d *DockerProvider) CreateUser(ctx context.Context, id, user, password string) error {
+	// Both end up in the arguments of adduser and the lines read by chpasswd,
+	// which splits them on the first colon so the password may contain more
+	if !validUser.MatchString(user) {
+		return fmt.Errorf("invalid username %q", user)
+	}
+	if strings.ContainsAny(password, "\r\n") {
+		return errors.New("password contains a newline")
+	}
+
+	if err := d.exec(ctx, id, "/bin/createuser", user, password); err != nil {
+		return err
+	}
+
+	// The files changed by createuser, such as /etc/shadow, are not artifacts of the attacker
+	d.setBaseline(ctx, id)
+	return nil
+}
+
+// exec runs a command on the host and waits for it to finish
+func (d *DockerProvider) exec(ctx context.Context, id string, cmd ...string) error {
+	exec, err := d.client.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := d.client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	// Wait for the command to finish
+	output := new(bytes.Buffer)
+	if _, err = stdcopy.StdCopy(output, output, res.Reader); err != nil {
+		return err
+	}
+
+	inspect, err := d.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d: %s", cmd[0], inspect.ExitCode, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// setBaseline records the modification times of the files changed on the host so
+// far. Files that have not been modified again are not reported as artifacts.
+func (d *DockerProvider) setBaseline(ctx context.Context, id string) {
+	d.RLock()
+	h, ok := d.containers[id]
+	d.RUnlock()
+	if !ok {
+		return
+	}
+
+	changes, err := d.client.ContainerDiff(ctx, id)
+	if err != nil {
+		log.Err(err).Str("id", id).Msg("Could not record the files changed on host")
+		return
+	}
+
+	baseline := map[string]time.Time{}
+	for _, change := range changes {
+		if change.Kind == container.ChangeDelete {
+			continue
+		}
+		stat, err := d.client.ContainerStatPath(ctx, id, change.Path)
+		if err != nil || !stat.Mode.IsRegular() {
+			continue
+		}
+		baseline[change.Path] = stat.Mtime
+	}
+	h.SetBaseline(baseline)
+}
+
+// GetArtifacts exports the regular files that have been added or modified
+// on the host since it was started. The content of files larger than
+// the configured max size is not exported, 0 means no limit.
+func (d *DockerProvider) GetArtifacts(ctx context.Context, id string) ([]Artifact, error) {
+	changes, err := d.client.ContainerDiff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := map[string]time.Time{}
+	d.RLock()
+	if h, ok := d.containers[id]; ok {
+		baseline = h.Baseline()
+	}
+	d.RUnlock()
+
+	artifacts := []Artifact{}
+	for _, change := range changes {
+		if change.Kind == container.ChangeDelete || ignoredArtifacts[change.Path] {
+			continue
+		}
+
+		stat, err := d.client.ContainerStatPath(ctx, id, change.Path)
+		if err != nil {
+			log.Err(err).Str("id", id).Str("path", change.Path).Msg("Could not stat changed file")
+			continue
+		}
+		if !stat.Mode.IsRegular() {
+			continue
+		}
+		if mtime, ok := baseline[change.Path]; ok && mtime.Equal(stat.Mtime) {
+			continue
+		}
+
+		a := Artifact{
+			ModTime: stat.Mtime,
+			Path:    change.Path,
+			Size:    stat.Size,
+			Mode:    stat.Mode,
+			Added:   change.Kind == container.ChangeAdd,
+		}
+		if d.maxArtifact <= 0 || stat.Size <= d.maxArtifact {
+			a.Data, err = d.readFile(ctx, id, change.Path)
+			if err != nil {
+				log.Err(err).Str("id", id).Str("path", change.Path).Msg("Could not export changed file")
+				continue
+			}
+		}
+		artifacts = append(artifacts, a)
+	}
+
+	return artifacts, nil
 }
 
 // StopHost stops a managed host
@@ -300,19 +495,128 @@ func (d *DockerProvider) StopHost(ctx context.Context, id string) error {
 	return d.deleteContainer(ctx, id)
 }
 
-func readTar(r io.ReadCloser) (str string, err error) {
+// Container describes a container managed by the provider
+type Container struct {
+	Created  time.Time `json:"created"`
+	ID       string    `json:"id"`
+	Image    string    `json:"image"`
+	Running  bool      `json:"running"`
+	Occupied bool      `json:"occupied"`
+}
+
+// Containers returns the containers managed by the provider, oldest first
+func (d *DockerProvider) Containers() []Container {
+	d.RLock()
+	res := make([]Container, 0, len(d.containers))
+	for _, h := range d.containers {
+		res = append(res, Container{
+			Created:  h.Created(),
+			ID:       h.ID(),
+			Image:    h.Image(),
+			Running:  h.Running(),
+			Occupied: h.Occupied(),
+		})
+	}
+	d.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.Before(res[j].Created) })
+	return res
+}
+
+// HostBuffer returns the amount of idle containers kept running
+func (d *DockerProvider) HostBuffer() int {
+	d.RLock()
+	defer d.RUnlock()
+	return d.hostBuffer
+}
+
+// SetHostBuffer sets the amount of idle containers kept running.
+// Superfluous idle containers are stopped.
+func (d *DockerProvider) SetHostBuffer(n int) {
+	d.Lock()
+	d.hostBuffer = n
+	d.Unlock()
+}
+
+// Image returns the image new containers are created from
+func (d *DockerProvider) Image() string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.config.Image
+}
+
+// SetImage pulls the image and creates new containers from it once a
+// container of it has passed checkImage. Idle containers running the
+// previous image are replaced, occupied ones keep running until their
+// session ends.
+func (d *DockerProvider) SetImage(ctx context.Context, image string) error {
+	if err := d.pullImage(ctx, image); err != nil {
+		return err
+	}
+	if err := d.checkImage(ctx, image); err != nil {
+		return fmt.Errorf("image %s is not usable as a honeypot: %w", image, err)
+	}
+	d.Lock()
+	d.config.Image = image
+	d.Unlock()
+	log.Info().Str("image", image).Msg("Honeypot image rotated")
+	return nil
+}
+
+// checkImage starts a container of the image that is not handed out
+// to sessions and checks that it is set up like honeypot/Dockerfile
+func (d *DockerProvider) checkImage(ctx context.Context, image string) (err error) {
+	d.RLock()
+	config := d.config
+	d.RUnlock()
+	config.Image = image
+
+	res, err := d.client.ContainerCreate(ctx, &config, &d.hostConfig, &d.networkConfig, &d.plaform, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The container may already be going away since it is auto removed
+		rmErr := d.client.ContainerRemove(ctx, res.ID, types.ContainerRemoveOptions{Force: true})
+		if !errdefs.IsNotFound(rmErr) && !errdefs.IsConflict(rmErr) {
+			err = errors.Join(err, rmErr)
+		}
+	}()
+
+	if err = d.client.ContainerStart(ctx, res.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	return d.exec(ctx, res.ID, "/bin/sh", "-c", imageCheck)
+}
+
+// readFile reads a file from the host
+func (d *DockerProvider) readFile(ctx context.Context, id, path string) ([]byte, error) {
+	r, _, err := d.client.CopyFromContainer(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	return readTar(r, d.maxArtifact)
+}
+
+// readTar reads the first file of the archive, failing if it is larger than
+// limit bytes unless limit is 0. The size in the header is not trusted since
+// the file may still be growing or sparse.
+func readTar(r io.ReadCloser, limit int64) (data []byte, err error) {
 	defer func() {
 		err = errors.Join(err, r.Close())
 	}()
 	tr := tar.NewReader(r)
 
-	_, err = tr.Next()
-	if err != nil {
-		return "", err
+	if _, err = tr.Next(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return io.ReadAll(tr)
 	}
 
-	buf := new(bytes.Buffer)
-	_, err = buf.ReadFrom(tr)
-
-	return buf.String(), err
+	data, err = io.ReadAll(io.LimitReader(tr, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	return data, err
 }
@@ -1,6 +1,10 @@
 package hostprovider
 
-import "context"
+import (
+	"context"
+	"os"
+	"time"
+)
 
 // SSH provides SSH hosts
 type SSH interface {
@@ -8,5 +12,17 @@ type SSH interface {
 	Stop(context.Context) error
 	GetHost(context.Context) (IP string, id string, err error)
 	StopHost(ctx context.Context, id string) error
+	CreateUser(ctx context.Context, id, user, password string) error
 	GetScriptOutput(ctx context.Context, id string) (string, string, error)
+	GetArtifacts(ctx context.Context, id string) ([]Artifact, error)
+}
+
+// Artifact is a file that has been added or modified on a host
+type Artifact struct {
+	ModTime time.Time
+	Path    string
+	Data    []byte // nil if the file is too large to be exported
+	Size    int64
+	Mode    os.FileMode
+	Added   bool // false if modified
 }
@@ -0,0 +1,36 @@
+package hostprovider
+
+import (
+	"github.com/alx99/botpot/internal/botpot/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	containers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "botpot_containers",
+		Help: "Containers in the pool by state.",
+	}, []string{"state"})
+	containerStarts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_container_starts_total",
+		Help: "Containers created and started.",
+	})
+	containerStartFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_container_start_failures_total",
+		Help: "Containers that could not be created or started.",
+	})
+	containerStartSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botpot_container_start_seconds",
+		Help:    "Time taken to create and start a container.",
+		Buckets: metrics.LatencyBuckets,
+	})
+	poolMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_pool_misses_total",
+		Help: "Hosts requested while no idle container was buffered.",
+	})
+	hostSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botpot_host_wait_seconds",
+		Help:    "Time taken to get a hold of a host for a session.",
+		Buckets: metrics.LatencyBuckets,
+	})
+)
@@ -0,0 +1,7 @@
+// Package metrics holds what the metrics of the other packages have in common.
+// Metrics are registered in the default Prometheus registry when they are
+// created, usually as package level variables.
+package metrics
+
+// LatencyBuckets are histogram buckets in seconds suitable for network operations
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
@@ -0,0 +1,282 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pageSize is the amount of sessions listed per page
+const pageSize = 50
+
+// dateLayout is the layout of the time filters, as sent by datetime-local inputs
+const dateLayout = "2006-01-02T15:04"
+
+var errNotFound = errors.New("session not found")
+
+// Filter limits the sessions listed
+type Filter struct {
+	From    *time.Time
+	To      *time.Time
+	IP      string // Address or CIDR
+	Command string // Matched against exec requests and the terminal output
+	Version string // Client version
+	Page    int
+}
+
+// parseFilter parses the filter from query parameters
+func parseFilter(q url.Values) (Filter, error) {
+	f := Filter{
+		IP:      q.Get("ip"),
+		Command: q.Get("command"),
+		Version: q.Get("version"),
+	}
+	if f.IP != "" && net.ParseIP(f.IP) == nil {
+		if _, _, err := net.ParseCIDR(f.IP); err != nil {
+			return f, fmt.Errorf("invalid IP address or CIDR %q", f.IP)
+		}
+	}
+	for _, t := range []struct {
+		dst  **time.Time
+		name string
+	}{{&f.From, "from"}, {&f.To, "to"}} {
+		if q.Get(t.name) == "" {
+			continue
+		}
+		ts, err := time.Parse(dateLayout, q.Get(t.name))
+		if err != nil {
+			return f, fmt.Errorf("invalid %s time %q", t.name, q.Get(t.name))
+		}
+		*t.dst = &ts
+	}
+	if p := q.Get("page"); p != "" {
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 0 {
+			return f, fmt.Errorf("invalid page %q", p)
+		}
+		f.Page = page
+	}
+	return f, nil
+}
+
+// Query returns the filter as query parameters for the given page
+func (f Filter) Query(page int) string {
+	q := url.Values{}
+	for k, v := range map[string]string{"ip": f.IP, "command": f.Command, "version": f.Version} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if f.From != nil {
+		q.Set("from", f.From.Format(dateLayout))
+	}
+	if f.To != nil {
+		q.Set("to", f.To.Format(dateLayout))
+	}
+	if page > 0 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return q.Encode()
+}
+
+// sessionRow is a session as listed
+type sessionRow struct {
+	Start     time.Time
+	End       *time.Time
+	SrcIP     string
+	Version   string
+	ID        int
+	SrcPort   int
+	Channels  int
+	HasScript bool
+}
+
+// listSessions lists the sessions matching the filter, newest first
+func listSessions(tx pgx.Tx, f Filter) ([]sessionRow, error) {
+	rows, err := tx.Query(context.TODO(), `
+	SELECT s.id, host(s.src_ip), s.src_port, s.version, s.start_ts, s.end_ts, s.timing <> '',
+		(SELECT count(*) FROM Channel c WHERE c.session_id = s.id)
+	FROM Session s
+	WHERE ($1::inet IS NULL OR s.src_ip <<= $1::inet)
+		AND ($2::timestamptz IS NULL OR s.start_ts >= $2)
+		AND ($3::timestamptz IS NULL OR s.start_ts < $3)
+		AND ($4::text IS NULL OR s.stdout ILIKE '%' || $4 || '%' OR EXISTS (
+			SELECT 1 FROM Request r JOIN ExecRequest e ON e.request_id = r.id
+				WHERE r.session_id = s.id AND e.command ILIKE '%' || $4 || '%'))
+		AND ($5::text IS NULL OR s.version ILIKE '%' || $5 || '%')
+	ORDER BY s.start_ts DESC
+	LIMIT $6 OFFSET $7
+`, nilIfEmpty(f.IP), f.From, f.To, nilIfEmpty(f.Command), nilIfEmpty(f.Version), pageSize+1, f.Page*pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (sessionRow, error) {
+		var s sessionRow
+		err := row.Scan(&s.ID, &s.SrcIP, &s.SrcPort, &s.Version, &s.Start, &s.End, &s.HasScript, &s.Channels)
+		return s, err
+	})
+}
+
+// sessionDetail is everything shown about a single session
+type sessionDetail struct {
+	sessionRow
+	UID      string
+	DstIP    string
+	DstPort  int
+	Auths    []authRow
+	Channels []channelRow
+}
+
+type authRow struct {
+	TS       time.Time
+	Method   string
+	Username string
+	Secret   string // Password, key fingerprint or answers
+	Accepted bool
+}
+
+type channelRow struct {
+	Start      time.Time
+	End        *time.Time
+	Type       string
+	Requests   []requestRow
+	SFTP       []sftpRow
+	ID         int
+	FromClient bool
+	HasPTY     bool
+}
+
+type requestRow struct {
+	TS       time.Time
+	Accepted *bool
+	Type     string
+	Detail   string // Command, variable or subsystem
+}
+
+type sftpRow struct {
+	TS            *time.Time
+	StatusCode    *int
+	StatusMessage *string
+	Operation     string
+	Path          string
+	TargetPath    string
+}
+
+// loadSession loads the session with its authentication attempts and channels
+func loadSession(tx pgx.Tx, id int) (sessionDetail, error) {
+	var s sessionDetail
+	err := tx.QueryRow(context.TODO(), `
+	SELECT id, uid, host(src_ip), src_port, host(dst_ip), dst_port, version, start_ts, end_ts, timing <> ''
+	FROM Session WHERE id = $1
+`, id).Scan(&s.ID, &s.UID, &s.SrcIP, &s.SrcPort, &s.DstIP, &s.DstPort, &s.Version, &s.Start, &s.End, &s.HasScript)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, errNotFound
+	} else if err != nil {
+		return s, err
+	}
+
+	rows, err := tx.Query(context.TODO(), `
+	SELECT ts, 'password', username, password, accepted FROM Credential WHERE session_id = $1
+	UNION ALL
+	SELECT ts, 'publickey', username, key_type || ' ' || fingerprint, accepted FROM PublicKey WHERE session_id = $1
+	UNION ALL
+	SELECT ts, 'keyboard-interactive', username, array_to_string(answers, ', '), accepted FROM KeyboardInteractive WHERE session_id = $1
+	ORDER BY 1
+`, id)
+	if err != nil {
+		return s, err
+	}
+	s.Auths, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (authRow, error) {
+		var a authRow
+		err := row.Scan(&a.TS, &a.Method, &a.Username, &a.Secret, &a.Accepted)
+		return a, err
+	})
+	if err != nil {
+		return s, err
+	}
+
+	rows, err = tx.Query(context.TODO(), `
+	SELECT c.id, c.channel_type, c.from_client, c.start_ts, c.end_ts, EXISTS (
+		SELECT 1 FROM Request r JOIN PTYRequest p ON p.request_id = r.id
+			WHERE r.session_id = c.session_id AND r.channel_id = c.id)
+	FROM Channel c WHERE c.session_id = $1
+	ORDER BY c.id
+`, id)
+	if err != nil {
+		return s, err
+	}
+	s.Channels, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (channelRow, error) {
+		var c channelRow
+		err := row.Scan(&c.ID, &c.Type, &c.FromClient, &c.Start, &c.End, &c.HasPTY)
+		return c, err
+	})
+	if err != nil {
+		return s, err
+	}
+
+	for i := range s.Channels {
+		if s.Channels[i].Requests, err = loadRequests(tx, id, s.Channels[i].ID); err != nil {
+			return s, err
+		}
+		if s.Channels[i].SFTP, err = loadSFTP(tx, id, s.Channels[i].ID); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// loadRequests loads the exec, env and subsystem requests of a channel
+func loadRequests(tx pgx.Tx, sessionID, channelID int) ([]requestRow, error) {
+	rows, err := tx.Query(context.TODO(), `
+	SELECT r.ts, r.type, r.accepted, COALESCE(e.command, v.name || '=' || v.value, s.name)
+	FROM Request r
+		LEFT JOIN ExecRequest e ON e.request_id = r.id
+		LEFT JOIN EnvironmentRequest v ON v.request_id = r.id
+		LEFT JOIN SubSystemRequest s ON s.request_id = r.id
+	WHERE r.session_id = $1 AND r.channel_id = $2 AND r.type IN ('exec', 'env', 'subsystem')
+	ORDER BY r.ts
+`, sessionID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (requestRow, error) {
+		var r requestRow
+		var detail *string
+		err := row.Scan(&r.TS, &r.Type, &r.Accepted, &detail)
+		if detail != nil {
+			r.Detail = *detail
+		}
+		return r, err
+	})
+}
+
+// loadSFTP loads the SFTP operations of a channel
+func loadSFTP(tx pgx.Tx, sessionID, channelID int) ([]sftpRow, error) {
+	rows, err := tx.Query(context.TODO(), `
+	SELECT request_ts, operation, path, target_path, status_code, status_message
+	FROM SFTPOperation
+	WHERE session_id = $1 AND channel_id = $2
+	ORDER BY id
+`, sessionID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (sftpRow, error) {
+		var o sftpRow
+		err := row.Scan(&o.TS, &o.Operation, &o.Path, &o.TargetPath, &o.StatusCode, &o.StatusMessage)
+		return o, err
+	})
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,168 @@
+// Package replay serves a web UI for browsing recorded
+// sessions and replaying them in the browser
+package replay
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/asciicast"
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// static holds the player, which is served by botpot itself
+// so that no third party script runs in the operator UI
+//
+//go:embed static
+var static embed.FS
+
+// UI serves the session list, session pages and their casts
+type UI struct {
+	db   *db.DB
+	tmpl *template.Template
+	mux  *http.ServeMux
+}
+
+// New creates a new UI reading sessions from the database
+func New(database *db.DB) *UI {
+	u := &UI{
+		db: database,
+		tmpl: template.Must(template.New("").Funcs(template.FuncMap{
+			"ts": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+			"page": func(f Filter, page int) template.URL {
+				// nolint:gosec // the query is encoded
+				return template.URL("/sessions?" + f.Query(page))
+			},
+		}).ParseFS(templates, "templates/*.html")),
+		mux: http.NewServeMux(),
+	}
+	u.mux.HandleFunc("/sessions", u.handleList)
+	u.mux.HandleFunc("/sessions/view", u.handleView)
+	u.mux.HandleFunc("/sessions/cast", u.handleCast)
+	u.mux.Handle("/sessions/static/", http.StripPrefix("/sessions/", http.FileServer(http.FS(static))))
+	return u
+}
+
+// ServeHTTP serves the UI
+func (u *UI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u.mux.ServeHTTP(w, r)
+}
+
+func (u *UI) handleList(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sessions []sessionRow
+	err = u.db.BeginTx(func(tx pgx.Tx) (err error) {
+		sessions, err = listSessions(tx, f)
+		return err
+	})
+	if err != nil {
+		u.fail(w, err)
+		return
+	}
+
+	// One extra session is fetched to know if there is a next page
+	more := len(sessions) > pageSize
+	if more {
+		sessions = sessions[:pageSize]
+	}
+	u.render(w, "list.html", map[string]any{
+		"Filter":   f,
+		"Sessions": sessions,
+		"Prev":     f.Page > 0,
+		"Next":     more,
+		"PrevPage": f.Page - 1,
+		"NextPage": f.Page + 1,
+	})
+}
+
+func (u *UI) handleView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	var s sessionDetail
+	err = u.db.BeginTx(func(tx pgx.Tx) (err error) {
+		s, err = loadSession(tx, id)
+		return err
+	})
+	if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		u.fail(w, err)
+		return
+	}
+	u.render(w, "view.html", s)
+}
+
+// handleCast serves the script recording of a session as asciicast,
+// or the data of a channel if the channel parameter is given
+func (u *UI) handleCast(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, err := strconv.Atoi(q.Get("id"))
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+	var channel uint64
+	if q.Has("channel") {
+		if channel, err = strconv.ParseUint(q.Get("channel"), 10, 32); err != nil || channel == 0 {
+			http.Error(w, "invalid channel id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var cast *asciicast.Cast
+	err = u.db.BeginTx(func(tx pgx.Tx) (err error) {
+		if channel == 0 {
+			cast, err = asciicast.LoadScript(tx, id)
+		} else {
+			cast, err = asciicast.LoadChannel(tx, id, uint32(channel), q.Get("input") == "true")
+		}
+		return err
+	})
+	if errors.Is(err, asciicast.ErrNoRecording) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		u.fail(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	if err = cast.Encode(w); err != nil {
+		log.Err(err).Int("session", id).Msg("Could not write cast")
+	}
+}
+
+func (u *UI) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := u.tmpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Err(err).Str("template", name).Msg("Could not render template")
+	}
+}
+
+func (u *UI) fail(w http.ResponseWriter, err error) {
+	log.Err(err).Msg("Could not query sessions")
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
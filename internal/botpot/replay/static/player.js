@@ -0,0 +1,572 @@
+// player.js plays asciicast v2 recordings in the browser. It is served by
+// botpot itself so that no third party script runs in the operator UI.
+// Only the escape sequences shells and common full screen tools use are
+// interpreted, everything else is skipped.
+(function () {
+  "use strict";
+
+  var PALETTE = [
+    "#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+    "#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff"
+  ];
+
+  // color returns the CSS color of an entry in the 256 color palette
+  function color(n) {
+    if (n < 16) {
+      return PALETTE[n];
+    }
+    if (n < 232) {
+      n -= 16;
+      var steps = [0, 95, 135, 175, 215, 255];
+      return "rgb(" + steps[Math.floor(n / 36)] + "," + steps[Math.floor(n / 6) % 6] + "," + steps[n % 6] + ")";
+    }
+    var v = 8 + (n - 232) * 10;
+    return "rgb(" + v + "," + v + "," + v + ")";
+  }
+
+  function blank(attr) {
+    return {c: " ", fg: attr.fg, bg: attr.bg, bold: attr.bold, inverse: attr.inverse};
+  }
+
+  function Terminal(cols, rows) {
+    this.reset(cols, rows);
+  }
+
+  Terminal.prototype.reset = function (cols, rows) {
+    this.cols = cols;
+    this.rows = rows;
+    this.attr = {fg: null, bg: null, bold: false, inverse: false};
+    this.lines = [];
+    for (var y = 0; y < rows; y++) {
+      this.lines.push(this.blankLine());
+    }
+    this.x = 0;
+    this.y = 0;
+    this.top = 0;
+    this.bottom = rows - 1;
+    this.saved = {x: 0, y: 0};
+    this.main = null; // lines of the main screen while the alternate one is shown
+    this.state = "ground";
+    this.params = "";
+  };
+
+  Terminal.prototype.blankLine = function () {
+    var line = [];
+    for (var x = 0; x < this.cols; x++) {
+      line.push(blank(this.attr));
+    }
+    return line;
+  };
+
+  Terminal.prototype.resize = function (cols, rows) {
+    var lines = this.lines;
+    this.cols = cols;
+    this.rows = rows;
+    this.lines = [];
+    for (var y = 0; y < rows; y++) {
+      var line = this.blankLine();
+      if (y < lines.length) {
+        for (var x = 0; x < cols && x < lines[y].length; x++) {
+          line[x] = lines[y][x];
+        }
+      }
+      this.lines.push(line);
+    }
+    this.top = 0;
+    this.bottom = rows - 1;
+    this.x = Math.min(this.x, cols - 1);
+    this.y = Math.min(this.y, rows - 1);
+  };
+
+  Terminal.prototype.scrollUp = function (n) {
+    for (var i = 0; i < n; i++) {
+      this.lines.splice(this.top, 1);
+      this.lines.splice(this.bottom, 0, this.blankLine());
+    }
+  };
+
+  Terminal.prototype.scrollDown = function (n) {
+    for (var i = 0; i < n; i++) {
+      this.lines.splice(this.bottom, 1);
+      this.lines.splice(this.top, 0, this.blankLine());
+    }
+  };
+
+  Terminal.prototype.lineFeed = function () {
+    if (this.y === this.bottom) {
+      this.scrollUp(1);
+    } else if (this.y < this.rows - 1) {
+      this.y++;
+    }
+  };
+
+  Terminal.prototype.put = function (c) {
+    if (this.x >= this.cols) {
+      this.x = 0;
+      this.lineFeed();
+    }
+    var cell = blank(this.attr);
+    cell.c = c;
+    this.lines[this.y][this.x] = cell;
+    this.x++;
+  };
+
+  Terminal.prototype.erase = function (y, from, to) {
+    for (var x = from; x < to && x < this.cols; x++) {
+      this.lines[y][x] = blank(this.attr);
+    }
+  };
+
+  Terminal.prototype.write = function (data) {
+    for (var i = 0; i < data.length; i++) {
+      var c = data[i];
+      switch (this.state) {
+        case "ground":
+          this.ground(c);
+          break;
+        case "escape":
+          this.escape(c);
+          break;
+        case "charset":
+          this.state = "ground";
+          break;
+        case "csi":
+          if (c >= "@" && c <= "~") {
+            this.state = "ground";
+            this.csi(c, this.params);
+          } else {
+            this.params += c;
+          }
+          break;
+        case "osc":
+          if (c === "\x07") {
+            this.state = "ground";
+          } else if (c === "\x1b") {
+            this.state = "charset"; // swallows the backslash of the string terminator
+          }
+          break;
+      }
+    }
+  };
+
+  Terminal.prototype.ground = function (c) {
+    switch (c) {
+      case "\x1b":
+        this.state = "escape";
+        break;
+      case "\r":
+        this.x = 0;
+        break;
+      case "\n":
+      case "\x0b":
+      case "\x0c":
+        this.lineFeed();
+        break;
+      case "\b":
+        this.x = Math.max(0, Math.min(this.x, this.cols - 1) - 1);
+        break;
+      case "\t":
+        this.x = Math.min(this.cols - 1, (Math.floor(this.x / 8) + 1) * 8);
+        break;
+      default:
+        if (c >= " " && c !== "\x7f") {
+          this.put(c);
+        }
+    }
+  };
+
+  Terminal.prototype.escape = function (c) {
+    this.state = "ground";
+    switch (c) {
+      case "[":
+        this.state = "csi";
+        this.params = "";
+        break;
+      case "]":
+        this.state = "osc";
+        break;
+      case "(":
+      case ")":
+        this.state = "charset";
+        break;
+      case "7":
+        this.saved = {x: this.x, y: this.y};
+        break;
+      case "8":
+        this.x = this.saved.x;
+        this.y = this.saved.y;
+        break;
+      case "D":
+        this.lineFeed();
+        break;
+      case "E":
+        this.x = 0;
+        this.lineFeed();
+        break;
+      case "M":
+        if (this.y === this.top) {
+          this.scrollDown(1);
+        } else if (this.y > 0) {
+          this.y--;
+        }
+        break;
+      case "c":
+        this.reset(this.cols, this.rows);
+        break;
+    }
+  };
+
+  Terminal.prototype.csi = function (final, params) {
+    var private_ = params[0] === "?";
+    var args = (private_ ? params.slice(1) : params).split(";").map(function (p) {
+      return parseInt(p, 10) || 0;
+    });
+    var n = Math.max(1, args[0]);
+    var y, i;
+
+    if (private_) {
+      if ((final === "h" || final === "l") && [47, 1047, 1049].indexOf(args[0]) >= 0) {
+        if (args[0] === 1049 && final === "h") {
+          this.saved = {x: this.x, y: this.y};
+        }
+        this.alternate(final === "h");
+        if (args[0] === 1049 && final === "l") {
+          this.x = this.saved.x;
+          this.y = this.saved.y;
+        }
+      }
+      return;
+    }
+
+    switch (final) {
+      case "A":
+        this.y = Math.max(0, this.y - n);
+        break;
+      case "B":
+        this.y = Math.min(this.rows - 1, this.y + n);
+        break;
+      case "C":
+        this.x = Math.min(this.cols - 1, this.x + n);
+        break;
+      case "D":
+        this.x = Math.max(0, Math.min(this.x, this.cols - 1) - n);
+        break;
+      case "E":
+        this.x = 0;
+        this.y = Math.min(this.rows - 1, this.y + n);
+        break;
+      case "F":
+        this.x = 0;
+        this.y = Math.max(0, this.y - n);
+        break;
+      case "G":
+        this.x = Math.min(this.cols - 1, n - 1);
+        break;
+      case "d":
+        this.y = Math.min(this.rows - 1, n - 1);
+        break;
+      case "H":
+      case "f":
+        this.y = Math.min(this.rows - 1, n - 1);
+        this.x = Math.min(this.cols - 1, Math.max(1, args[1] || 0) - 1);
+        break;
+      case "J":
+        if (args[0] === 0) {
+          this.erase(this.y, this.x, this.cols);
+          for (y = this.y + 1; y < this.rows; y++) {
+            this.erase(y, 0, this.cols);
+          }
+        } else if (args[0] === 1) {
+          this.erase(this.y, 0, this.x + 1);
+          for (y = 0; y < this.y; y++) {
+            this.erase(y, 0, this.cols);
+          }
+        } else {
+          for (y = 0; y < this.rows; y++) {
+            this.erase(y, 0, this.cols);
+          }
+        }
+        break;
+      case "K":
+        if (args[0] === 0) {
+          this.erase(this.y, this.x, this.cols);
+        } else if (args[0] === 1) {
+          this.erase(this.y, 0, this.x + 1);
+        } else {
+          this.erase(this.y, 0, this.cols);
+        }
+        break;
+      case "L":
+        for (i = 0; i < n && this.y <= this.bottom; i++) {
+          this.lines.splice(this.bottom, 1);
+          this.lines.splice(this.y, 0, this.blankLine());
+        }
+        break;
+      case "M":
+        for (i = 0; i < n && this.y <= this.bottom; i++) {
+          this.lines.splice(this.y, 1);
+          this.lines.splice(this.bottom, 0, this.blankLine());
+        }
+        break;
+      case "@":
+        for (i = 0; i < n; i++) {
+          this.lines[this.y].splice(this.x, 0, blank(this.attr));
+          this.lines[this.y].length = this.cols;
+        }
+        break;
+      case "P":
+        for (i = 0; i < n; i++) {
+          this.lines[this.y].splice(this.x, 1);
+          this.lines[this.y].push(blank(this.attr));
+        }
+        break;
+      case "X":
+        this.erase(this.y, this.x, this.x + n);
+        break;
+      case "S":
+        this.scrollUp(n);
+        break;
+      case "T":
+        this.scrollDown(n);
+        break;
+      case "r":
+        this.top = Math.min(this.rows - 1, n - 1);
+        this.bottom = Math.min(this.rows - 1, (args[1] || this.rows) - 1);
+        if (this.top >= this.bottom) {
+          this.top = 0;
+          this.bottom = this.rows - 1;
+        }
+        this.x = 0;
+        this.y = 0;
+        break;
+      case "s":
+        this.saved = {x: this.x, y: this.y};
+        break;
+      case "u":
+        this.x = this.saved.x;
+        this.y = this.saved.y;
+        break;
+      case "m":
+        this.sgr(args);
+        break;
+    }
+  };
+
+  Terminal.prototype.alternate = function (enter) {
+    if (enter && !this.main) {
+      this.main = this.lines;
+      this.lines = [];
+      for (var y = 0; y < this.rows; y++) {
+        this.lines.push(this.blankLine());
+      }
+    } else if (!enter && this.main) {
+      this.lines = this.main;
+      this.main = null;
+    }
+  };
+
+  Terminal.prototype.sgr = function (args) {
+    var a = this.attr;
+    for (var i = 0; i < args.length; i++) {
+      var p = args[i];
+      if (p === 0) {
+        this.attr = a = {fg: null, bg: null, bold: false, inverse: false};
+      } else if (p === 1) {
+        a.bold = true;
+      } else if (p === 22) {
+        a.bold = false;
+      } else if (p === 7) {
+        a.inverse = true;
+      } else if (p === 27) {
+        a.inverse = false;
+      } else if (p >= 30 && p <= 37) {
+        a.fg = color(p - 30);
+      } else if (p >= 90 && p <= 97) {
+        a.fg = color(p - 90 + 8);
+      } else if (p === 39) {
+        a.fg = null;
+      } else if (p >= 40 && p <= 47) {
+        a.bg = color(p - 40);
+      } else if (p >= 100 && p <= 107) {
+        a.bg = color(p - 100 + 8);
+      } else if (p === 49) {
+        a.bg = null;
+      } else if ((p === 38 || p === 48) && args[i + 1] === 5) {
+        a[p === 38 ? "fg" : "bg"] = color(args[i + 2] & 255);
+        i += 2;
+      } else if ((p === 38 || p === 48) && args[i + 1] === 2) {
+        a[p === 38 ? "fg" : "bg"] = "rgb(" + args[i + 2] + "," + args[i + 3] + "," + args[i + 4] + ")";
+        i += 4;
+      }
+    }
+  };
+
+  // render replaces the content of the element with the screen
+  Terminal.prototype.render = function (el) {
+    el.textContent = "";
+    for (var y = 0; y < this.rows; y++) {
+      var line = this.lines[y];
+      var run = null;
+      var key = null;
+      for (var x = 0; x < line.length; x++) {
+        var cell = line[x];
+        var fg = cell.inverse ? cell.bg || "#000" : cell.fg;
+        var bg = cell.inverse ? cell.fg || "#e5e5e5" : cell.bg;
+        var k = fg + "|" + bg + "|" + cell.bold;
+        if (k !== key) {
+          run = document.createElement("span");
+          if (fg) {
+            run.style.color = fg;
+          }
+          if (bg) {
+            run.style.background = bg;
+          }
+          if (cell.bold) {
+            run.style.fontWeight = "bold";
+          }
+          el.appendChild(run);
+          key = k;
+        }
+        run.textContent += cell.c;
+      }
+      el.appendChild(document.createTextNode("\n"));
+    }
+  };
+
+  // parse parses an asciicast v2 recording
+  function parse(text) {
+    var lines = text.split("\n").filter(function (l) {
+      return l.trim() !== "";
+    });
+    var header = JSON.parse(lines[0]);
+    var events = lines.slice(1).map(function (l) {
+      return JSON.parse(l);
+    });
+    return {header: header, events: events};
+  }
+
+  function Player(cast, el, opts) {
+    this.header = cast.header;
+    this.events = cast.events;
+    this.duration = this.events.length ? this.events[this.events.length - 1][0] : 0;
+    this.term = new Terminal(this.header.width || 80, this.header.height || 24);
+    this.next = 0;
+    this.time = 0;
+    this.timer = null;
+
+    el.textContent = "";
+    el.classList.add("botpot-player");
+    this.screen = document.createElement("pre");
+    this.screen.className = "botpot-player-screen";
+    var controls = document.createElement("div");
+    controls.className = "botpot-player-controls";
+    this.button = document.createElement("button");
+    this.button.type = "button";
+    this.seek = document.createElement("input");
+    this.seek.type = "range";
+    this.seek.min = 0;
+    this.seek.max = Math.ceil(this.duration * 10);
+    this.clock = document.createElement("span");
+    controls.appendChild(this.button);
+    controls.appendChild(this.seek);
+    controls.appendChild(this.clock);
+    el.appendChild(this.screen);
+    el.appendChild(controls);
+
+    var self = this;
+    this.button.addEventListener("click", function () {
+      if (self.timer) {
+        self.pause();
+      } else {
+        self.play();
+      }
+    });
+    this.seek.addEventListener("input", function () {
+      self.pause();
+      self.jump(self.seek.value / 10);
+    });
+
+    this.update();
+    if (opts.autoPlay) {
+      this.play();
+    }
+  }
+
+  // apply applies all events up to the given time
+  Player.prototype.apply = function (time) {
+    while (this.next < this.events.length && this.events[this.next][0] <= time) {
+      var e = this.events[this.next++];
+      if (e[1] === "o") {
+        this.term.write(e[2]);
+      } else if (e[1] === "r") {
+        var size = e[2].split("x");
+        this.term.resize(parseInt(size[0], 10) || 80, parseInt(size[1], 10) || 24);
+      }
+    }
+    this.time = time;
+  };
+
+  Player.prototype.jump = function (time) {
+    if (time < this.time) {
+      this.term.reset(this.header.width || 80, this.header.height || 24);
+      this.next = 0;
+    }
+    this.apply(time);
+    this.update();
+  };
+
+  Player.prototype.play = function () {
+    if (this.next >= this.events.length) {
+      this.jump(0);
+    }
+    var self = this;
+    var start = performance.now() - this.time * 1000;
+    var tick = function () {
+      self.apply((performance.now() - start) / 1000);
+      self.update();
+      if (self.next >= self.events.length) {
+        self.pause();
+        return;
+      }
+      self.timer = setTimeout(tick, Math.max(0, (self.events[self.next][0] - self.time) * 1000));
+    };
+    this.timer = setTimeout(tick, 0);
+    this.update();
+  };
+
+  Player.prototype.pause = function () {
+    clearTimeout(this.timer);
+    this.timer = null;
+    this.update();
+  };
+
+  Player.prototype.update = function () {
+    this.term.render(this.screen);
+    this.button.textContent = this.timer ? "Pause" : "Play";
+    this.seek.value = Math.round(this.time * 10);
+    this.clock.textContent = format(this.time) + " / " + format(this.duration);
+  };
+
+  function format(seconds) {
+    var s = Math.floor(seconds);
+    var m = Math.floor(s / 60);
+    s %= 60;
+    return m + ":" + (s < 10 ? "0" : "") + s;
+  }
+
+  window.BotpotPlayer = {
+    // create loads the recording from src and plays it in el
+    create: function (src, el, opts) {
+      fetch(src, {credentials: "same-origin"}).then(function (res) {
+        if (!res.ok) {
+          throw new Error(res.status + " " + res.statusText);
+        }
+        return res.text();
+      }).then(function (text) {
+        return new Player(parse(text), el, opts || {});
+      }).catch(function (err) {
+        el.textContent = "Could not load recording: " + err.message;
+      });
+    }
+  };
+})();
@@ -0,0 +1,63 @@
+package scp
+
+import (
+	"path"
+	"strings"
+)
+
+// Command is an scp command run in sink (-t) or source (-f) mode
+type Command struct {
+	Target string
+	// Upload is true if the client sends files to the server (-t)
+	// and false if it receives them from it (-f)
+	Upload bool
+	// TargetIsDir is true if the target is known to be a directory
+	TargetIsDir bool
+}
+
+// ParseCommand parses the command of an exec request and
+// reports whether it runs scp in sink or source mode
+func ParseCommand(command string) (Command, bool) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || path.Base(fields[0]) != "scp" {
+		return Command{}, false
+	}
+
+	cmd := Command{}
+	mode := false
+	flagsDone := false
+	for _, f := range fields[1:] {
+		if !flagsDone && f == "--" {
+			flagsDone = true
+			continue
+		}
+		if flagsDone || !strings.HasPrefix(f, "-") || f == "-" {
+			cmd.Target = unquote(f)
+			continue
+		}
+		for _, flag := range f[1:] {
+			switch flag {
+			case 't':
+				cmd.Upload, mode = true, true
+			case 'f':
+				cmd.Upload, mode = false, true
+			case 'd', 'r':
+				cmd.TargetIsDir = true
+			}
+		}
+	}
+
+	if strings.HasSuffix(cmd.Target, "/") || cmd.Target == "." || cmd.Target == "~" {
+		cmd.TargetIsDir = true
+	}
+	return cmd, mode
+}
+
+// unquote removes the quotes added by scp clients
+// to protect the target from the remote shell
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,26 @@
+package scp
+
+import (
+	"context"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Insert tries to insert the file into the database
+func (f *File) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO FileTransfer(session_id, channel_id, protocol, upload, path, size, sha256, mode, complete)
+		VALUES($1, $2, 'scp', $3, $4, $5, $6, $7, $8)
+`, sessionID, channelID, f.Upload, db.Sanitize(f.Path), len(f.Data), f.SHA256, f.Mode, f.Complete)
+	return err
+}
+
+// Insert tries to insert the anomaly into the database
+func (a *Anomaly) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO TransferAnomaly(session_id, channel_id, protocol, ts, from_client, description)
+		VALUES($1, $2, 'scp', $3, $4, $5)
+`, sessionID, channelID, a.TS, a.FromClient, db.Sanitize(a.Description))
+	return err
+}
@@ -0,0 +1,273 @@
+package scp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxRecordLength is the longest record line accepted
+const maxRecordLength = 64 * 1024
+
+// maxFileSize and maxParsedSize limit how much data is kept of a single
+// file and of all files of a parser, data exceeding them is discarded
+const (
+	maxFileSize   = 64 * 1024 * 1024
+	maxParsedSize = 256 * 1024 * 1024
+)
+
+// File is a file transferred over SCP
+type File struct {
+	Path     string
+	SHA256   string
+	Data     []byte
+	Mode     uint32
+	Upload   bool // false if the file was downloaded
+	Complete bool // false if the transfer was cut short or exceeded the size limits
+}
+
+// Anomaly is something unexpected found in the SCP data
+type Anomaly struct {
+	TS          time.Time
+	Description string
+	FromClient  bool
+}
+
+// Parser parses the records sent by the source of an SCP transfer
+// as they arrive. The acknowledgements sent by the sink are ignored.
+//
+// The protocol is undocumented, the implementation is based on
+// https://github.com/openssh/openssh-portable/blob/master/scp.c
+type Parser struct {
+	cmd       Command
+	l         zerolog.Logger
+	buf       bytes.Buffer
+	dirs      []string
+	current   *File
+	remaining int64
+	size      int64 // Bytes held in all files
+	Files     []File
+	Anomalies []Anomaly
+	expectNul bool // Sent by the source after the content of a file
+	truncated bool // true if data of the current file was discarded
+	broken    bool // true if the stream can no longer be parsed
+	sync.Mutex
+}
+
+// NewParser creates a new SCP parser for the given command
+func NewParser(cmd Command, logger zerolog.Logger) *Parser {
+	return &Parser{cmd: cmd, l: logger}
+}
+
+// Write feeds data sent at ts in one direction of the channel to the parser
+func (p *Parser) Write(fromClient bool, ts time.Time, data []byte) {
+	p.Lock()
+	defer p.Unlock()
+
+	if fromClient != p.cmd.Upload || p.broken {
+		return
+	}
+	p.buf.Write(data)
+
+	for p.buf.Len() > 0 {
+		if p.current != nil {
+			n := p.remaining
+			if int64(p.buf.Len()) < n {
+				n = int64(p.buf.Len())
+			}
+			p.keep(ts, p.buf.Next(int(n)))
+			p.remaining -= n
+			if p.remaining == 0 {
+				p.current.Complete = !p.truncated
+				p.addFile()
+				p.expectNul = true
+			}
+			continue
+		}
+
+		if p.expectNul {
+			p.expectNul = false
+			if p.buf.Bytes()[0] == 0 {
+				p.buf.Next(1)
+				continue
+			}
+			// Otherwise the source failed to read the file and sends an error
+		}
+
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			if p.buf.Len() > maxRecordLength {
+				p.addAnomaly(ts, "record too long, giving up on the stream")
+				p.broken = true
+				p.buf.Reset()
+			}
+			return // Wait for more data
+		}
+
+		line := string(p.buf.Next(i + 1))
+		if err := p.parseRecord(strings.TrimSuffix(line, "\n")); err != nil {
+			p.addAnomaly(ts, fmt.Sprintf("malformed record %q: %s", truncate(line), err))
+		}
+	}
+}
+
+// Skip tells the parser that n bytes of one direction will never be written to it
+func (p *Parser) Skip(fromClient bool, ts time.Time, n int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if fromClient != p.cmd.Upload || p.broken {
+		return
+	}
+	p.addAnomaly(ts, fmt.Sprintf("%d bytes were not captured, giving up on the stream", n))
+	p.broken = true
+	p.buf.Reset()
+	if p.current != nil {
+		p.addFile()
+	}
+}
+
+// Close finishes the parsing once there is no more data
+func (p *Parser) Close(ts time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.current != nil {
+		p.addAnomaly(ts, fmt.Sprintf("%s is missing %d bytes at disconnect", p.current.Path, p.remaining))
+		p.addFile()
+	}
+	if p.buf.Len() > 0 {
+		p.addAnomaly(ts, fmt.Sprintf("partial record of %d bytes at disconnect", p.buf.Len()))
+		p.buf.Reset()
+	}
+
+	p.l.Debug().
+		Int("files", len(p.Files)).
+		Int("anomalies", len(p.Anomalies)).
+		Msg("Finished parsing SCP records")
+}
+
+// parseRecord parses a single record without its trailing newline
+func (p *Parser) parseRecord(line string) error {
+	if line == "" {
+		return fmt.Errorf("empty record")
+	}
+
+	switch line[0] {
+	case 'C', 'D':
+		// C<mode> <size> <name> or D<mode> 0 <name>
+		parts := strings.SplitN(line[1:], " ", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("expected mode, size and name")
+		}
+		mode, err := strconv.ParseUint(parts[0], 8, 32)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			return fmt.Errorf("negative size")
+		}
+		name := parts[2]
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			// Rejected by OpenSSH, but interesting nonetheless
+			p.l.Warn().Str("name", name).Msg("Suspicious SCP file name")
+		}
+
+		if line[0] == 'D' {
+			p.dirs = append(p.dirs, name)
+			return nil
+		}
+
+		p.current = &File{Path: p.path(name), Mode: uint32(mode), Upload: p.cmd.Upload}
+		p.remaining = size
+		if size == 0 {
+			p.current.Complete = true
+			p.addFile()
+			p.expectNul = true
+		}
+	case 'E':
+		if len(p.dirs) == 0 {
+			return fmt.Errorf("end of directory outside of a directory")
+		}
+		p.dirs = p.dirs[:len(p.dirs)-1]
+	case 'T':
+		// T<mtime> 0 <atime> 0
+		var mtime, mtimeUsec, atime, atimeUsec int64
+		if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mtime, &mtimeUsec, &atime, &atimeUsec); err != nil {
+			return err
+		}
+	case '\x01', '\x02':
+		// Warnings and errors, for example when a file can not be read
+		p.l.Debug().Str("message", line[1:]).Msg("SCP error")
+	default:
+		return fmt.Errorf("unknown record type")
+	}
+	return nil
+}
+
+// path returns the path of a file on the server
+func (p *Parser) path(name string) string {
+	parts := append(append([]string{}, p.dirs...), name)
+	if !p.cmd.Upload {
+		// The source sends the base name of what it was asked for
+		return path.Join(append([]string{path.Dir(p.cmd.Target)}, parts...)...)
+	}
+
+	// Whether the target is a directory is only known to the sink,
+	// assume that it is unless it already names the file
+	if !p.cmd.TargetIsDir && len(p.dirs) == 0 && path.Base(p.cmd.Target) == name {
+		return p.cmd.Target
+	}
+	return path.Join(append([]string{p.cmd.Target}, parts...)...)
+}
+
+// keep appends data to the current file unless it would
+// exceed the size limits, which is reported once per file
+func (p *Parser) keep(ts time.Time, data []byte) {
+	n := int64(len(data))
+	if int64(len(p.current.Data))+n > maxFileSize || p.size+n > maxParsedSize {
+		if !p.truncated {
+			p.truncated = true
+			p.addAnomaly(ts, fmt.Sprintf("%s exceeds the size limits, only %d bytes are kept", p.current.Path, len(p.current.Data)))
+		}
+		return
+	}
+	p.current.Data = append(p.current.Data, data...)
+	p.size += n
+}
+
+func (p *Parser) addFile() {
+	f := *p.current
+	p.current, p.remaining, p.truncated = nil, 0, false
+
+	sum := sha256.Sum256(f.Data)
+	f.SHA256 = hex.EncodeToString(sum[:])
+	p.l.Info().Str("path", f.Path).Int("size", len(f.Data)).Str("sha256", f.SHA256).Bool("upload", f.Upload).
+		Msg("Reconstructed SCP file")
+	p.Files = append(p.Files, f)
+}
+
+func (p *Parser) addAnomaly(ts time.Time, description string) {
+	p.l.Warn().Bool("fromClient", p.cmd.Upload).Str("anomaly", description).Msg("SCP anomaly")
+	p.Anomalies = append(p.Anomalies, Anomaly{TS: ts, Description: description, FromClient: p.cmd.Upload})
+}
+
+// truncate shortens a record for use in an anomaly
+func truncate(s string) string {
+	if len(s) > 64 {
+		return s[:64] + "..."
+	}
+	return s
+}
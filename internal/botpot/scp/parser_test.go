@@ -0,0 +1,246 @@
+package scp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// chunk is data sent in one direction of the channel
+type chunk struct {
+	data       string
+	fromClient bool
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    Command
+		ok      bool
+	}{
+		{command: "scp -t /tmp", want: Command{Target: "/tmp", Upload: true}, ok: true},
+		{command: "scp -t /tmp/", want: Command{Target: "/tmp/", Upload: true, TargetIsDir: true}, ok: true},
+		{command: "scp -r -t .", want: Command{Target: ".", Upload: true, TargetIsDir: true}, ok: true},
+		{command: "scp -dt '/tmp/x'", want: Command{Target: "/tmp/x", Upload: true, TargetIsDir: true}, ok: true},
+		{command: "/usr/bin/scp -f /etc/passwd", want: Command{Target: "/etc/passwd"}, ok: true},
+		{command: "scp -v -f -- -file", want: Command{Target: "-file"}, ok: true},
+		{command: "scp /etc/passwd", want: Command{Target: "/etc/passwd"}},
+		{command: "ls -t /tmp"},
+		{command: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, ok := ParseCommand(tt.command)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %+v %v, want %+v %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		chunks    []chunk
+		files     []File
+		anomalies []string // Substrings of the anomalies, in order
+	}{
+		{
+			name:    "upload into a directory",
+			command: "scp -t /tmp",
+			chunks:  []chunk{{data: "C0755 5 a.sh\nhello\x00", fromClient: true}},
+			files:   []File{{Path: "/tmp/a.sh", Data: []byte("hello"), Mode: 0755, Upload: true, Complete: true}},
+		},
+		{
+			name:    "upload to a file",
+			command: "scp -t /tmp/a.sh",
+			chunks:  []chunk{{data: "C0644 5 a.sh\nhello\x00", fromClient: true}},
+			files:   []File{{Path: "/tmp/a.sh", Data: []byte("hello"), Mode: 0644, Upload: true, Complete: true}},
+		},
+		{
+			name:    "recursive upload with times",
+			command: "scp -r -p -t /tmp",
+			chunks: []chunk{{data: "D0755 0 dir\nT1700000000 0 1700000000 0\nC0600 3 f\nabc\x00E\nC0644 1 g\nx\x00",
+				fromClient: true}},
+			files: []File{
+				{Path: "/tmp/dir/f", Data: []byte("abc"), Mode: 0600, Upload: true, Complete: true},
+				{Path: "/tmp/g", Data: []byte("x"), Mode: 0644, Upload: true, Complete: true},
+			},
+		},
+		{
+			name:    "upload split into single bytes",
+			command: "scp -t /tmp",
+			chunks:  splitBytes("C0644 5 a.sh\nhello\x00C0644 0 b\n\x00", true),
+			files: []File{
+				{Path: "/tmp/a.sh", Data: []byte("hello"), Mode: 0644, Upload: true, Complete: true},
+				{Path: "/tmp/b", Mode: 0644, Upload: true, Complete: true},
+			},
+		},
+		{
+			name:    "download",
+			command: "scp -f /etc/passwd",
+			chunks: []chunk{
+				{data: "\x00", fromClient: true},
+				{data: "C0644 4 passwd\n"},
+				{data: "\x00", fromClient: true},
+				{data: "root\x00"},
+				{data: "\x00", fromClient: true},
+			},
+			files: []File{{Path: "/etc/passwd", Data: []byte("root"), Mode: 0644, Complete: true}},
+		},
+		{
+			name:    "data of the sink is ignored",
+			command: "scp -t /tmp",
+			chunks:  []chunk{{data: "C0644 4 passwd\nroot\x00"}},
+		},
+		{
+			name:    "source fails to read a file",
+			command: "scp -f /etc/shadow",
+			chunks:  []chunk{{data: "\x01scp: /etc/shadow: Permission denied\n"}},
+		},
+		{
+			name:    "malformed records",
+			command: "scp -t /tmp",
+			chunks: []chunk{{data: "X\nC0644 abc f\nC0999 1 f\nC0644 -1 f\nC0644 1\nE\nT1 2\n\nC0644 1 ok\nx\x00",
+				fromClient: true}},
+			files: []File{{Path: "/tmp/ok", Data: []byte("x"), Mode: 0644, Upload: true, Complete: true}},
+			anomalies: []string{
+				`malformed record "X\n": unknown record type`,
+				`malformed record "C0644 abc f\n"`,
+				`malformed record "C0999 1 f\n"`,
+				`malformed record "C0644 -1 f\n": negative size`,
+				`malformed record "C0644 1\n": expected mode, size and name`,
+				`malformed record "E\n": end of directory outside of a directory`,
+				`malformed record "T1 2\n"`,
+				`malformed record "\n": empty record`,
+			},
+		},
+		{
+			name:      "file cut short",
+			command:   "scp -t /tmp",
+			chunks:    []chunk{{data: "C0644 10 f\nabc", fromClient: true}},
+			files:     []File{{Path: "/tmp/f", Data: []byte("abc"), Mode: 0644, Upload: true}},
+			anomalies: []string{"/tmp/f is missing 7 bytes at disconnect"},
+		},
+		{
+			name:      "partial record",
+			command:   "scp -t /tmp",
+			chunks:    []chunk{{data: "C0644", fromClient: true}},
+			anomalies: []string{"partial record of 5 bytes at disconnect"},
+		},
+		{
+			name:    "record too long",
+			command: "scp -t /tmp",
+			chunks: []chunk{
+				{data: "C0644 1 " + strings.Repeat("a", maxRecordLength), fromClient: true},
+				{data: "\nC0644 1 f\nx\x00", fromClient: true},
+			},
+			anomalies: []string{"record too long"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, ok := ParseCommand(tt.command)
+			if !ok {
+				t.Fatalf("%q does not run scp", tt.command)
+			}
+			p := NewParser(cmd, zerolog.Nop())
+			for _, c := range tt.chunks {
+				p.Write(c.fromClient, time.Now(), []byte(c.data))
+			}
+			p.Close(time.Now())
+
+			checkFiles(t, p.Files, tt.files)
+			checkAnomalies(t, p.Anomalies, tt.anomalies)
+		})
+	}
+}
+
+func TestParserSkip(t *testing.T) {
+	cmd, _ := ParseCommand("scp -t /tmp")
+	p := NewParser(cmd, zerolog.Nop())
+	p.Write(true, time.Now(), []byte("C0644 10 f\nabc"))
+	p.Skip(true, time.Now(), 4)
+	p.Write(true, time.Now(), []byte("xyz\x00C0644 1 g\nx\x00"))
+	p.Close(time.Now())
+
+	checkFiles(t, p.Files, []File{{Path: "/tmp/f", Data: []byte("abc"), Mode: 0644, Upload: true}})
+	checkAnomalies(t, p.Anomalies, []string{"4 bytes were not captured"})
+}
+
+func TestKeepLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileSize  int
+		held      int64 // Bytes held by other files
+		data      string
+		want      int
+		truncated bool
+	}{
+		{name: "within limits", fileSize: 3, data: "abc", want: 6},
+		{name: "file too large", fileSize: maxFileSize - 1, data: "ab", want: maxFileSize - 1, truncated: true},
+		{name: "parser full", fileSize: 1, held: maxParsedSize - 2, data: "ab", want: 1, truncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, _ := ParseCommand("scp -t /tmp")
+			p := NewParser(cmd, zerolog.Nop())
+			p.current = &File{Path: "/tmp/f", Data: make([]byte, tt.fileSize)}
+			p.size = tt.held + int64(tt.fileSize)
+
+			p.keep(time.Now(), []byte(tt.data))
+			if len(p.current.Data) != tt.want {
+				t.Errorf("got %d bytes, want %d", len(p.current.Data), tt.want)
+			}
+			if p.truncated != tt.truncated {
+				t.Errorf("got truncated %v, want %v", p.truncated, tt.truncated)
+			}
+			if tt.truncated {
+				// Only reported once per file
+				p.keep(time.Now(), []byte(tt.data))
+				checkAnomalies(t, p.Anomalies, []string{"/tmp/f exceeds the size limits"})
+			}
+		})
+	}
+}
+
+// splitBytes sends data one byte at a time
+func splitBytes(data string, fromClient bool) []chunk {
+	res := make([]chunk, len(data))
+	for i := range data {
+		res[i] = chunk{data: data[i : i+1], fromClient: fromClient}
+	}
+	return res
+}
+
+func checkFiles(t *testing.T, got, want []File) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d files, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Path != w.Path || string(g.Data) != string(w.Data) || g.Mode != w.Mode || g.Upload != w.Upload || g.Complete != w.Complete {
+			t.Errorf("got file %q %q mode=%o upload=%v complete=%v, want %q %q mode=%o upload=%v complete=%v",
+				g.Path, g.Data, g.Mode, g.Upload, g.Complete, w.Path, w.Data, w.Mode, w.Upload, w.Complete)
+		}
+	}
+}
+
+func checkAnomalies(t *testing.T, got []Anomaly, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got anomalies %+v, want %q", got, want)
+	}
+	for i, w := range want {
+		if !strings.Contains(got[i].Description, w) {
+			t.Errorf("got anomaly %q, want it to contain %q", got[i].Description, w)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sftp
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Insert tries to insert the operation into the database
+func (o *Operation) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	var statusCode *uint32
+	var statusMsg *string
+	if o.Status != nil {
+		msg := db.Sanitize(o.Status.Message)
+		statusCode, statusMsg = &o.Status.ErrorCode, &msg
+	}
+
+	// The attributes are attacker controlled and may contain NUL characters
+	var attrs *string
+	if o.Attrs != nil {
+		data, err := json.Marshal(o.Attrs)
+		if err != nil {
+			return err
+		}
+		str := string(db.SanitizeJSON(data))
+		attrs = &str
+	}
+
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO SFTPOperation(session_id, channel_id, request_id, operation, path, target_path, flags, attrs, handle, status_code, status_message, request_ts, reply_ts)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+`, sessionID, channelID, o.RequestID, o.Type, db.Sanitize(o.Path), db.Sanitize(o.TargetPath), o.Flags, attrs, []byte(o.Handle),
+		statusCode, statusMsg, nullTime(o.RequestTS), nullTime(o.ReplyTS))
+	return err
+}
+
+// Insert tries to insert the file into the database
+func (f *File) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO FileTransfer(session_id, channel_id, protocol, upload, path, size, sha256, complete)
+		VALUES($1, $2, 'sftp', $3, $4, $5, $6, $7)
+`, sessionID, channelID, f.Upload, db.Sanitize(f.Path), len(f.Data), f.SHA256, f.Complete)
+	return err
+}
+
+// Insert tries to insert the anomaly into the database
+func (a *Anomaly) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO TransferAnomaly(session_id, channel_id, protocol, ts, from_client, description)
+		VALUES($1, $2, 'sftp', $3, $4, $5)
+`, sessionID, channelID, a.TS, a.FromClient, db.Sanitize(a.Description))
+	return err
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -3,8 +3,11 @@ package sftp
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 )
 
+var errShortPacket = errors.New("packet too short")
+
 type packetBuffer struct {
 	buf *bytes.Buffer
 	len int
@@ -18,11 +21,11 @@ func newPacketBuffer(data []byte) packetBuffer {
 }
 
 func (pb *packetBuffer) getRemainingBytes() []byte {
-	v := make([]byte, pb.len)
-	if err := binary.Read(pb.buf, binary.BigEndian, &v); err != nil {
-		return []byte{}
-	}
-	return v
+	return pb.buf.Next(pb.buf.Len())
+}
+
+func (pb *packetBuffer) remaining() int {
+	return pb.buf.Len()
 }
 
 func (pb *packetBuffer) readUint8() (uint8, error) {
@@ -33,6 +36,11 @@ func (pb *packetBuffer) readUint8() (uint8, error) {
 	return v, nil
 }
 
+func (pb *packetBuffer) readBool() (bool, error) {
+	v, err := pb.readUint8()
+	return v != 0, err
+}
+
 func (pb *packetBuffer) readUint32() (uint32, error) {
 	var v uint32
 	if err := binary.Read(pb.buf, binary.BigEndian, &v); err != nil {
@@ -57,15 +65,25 @@ func (pb *packetBuffer) readInt64() (int64, error) {
 	return v, nil
 }
 
-func (pb *packetBuffer) readUTF8() (string, error) {
+func (pb *packetBuffer) readBytes() ([]byte, error) {
 	var strLen uint32
 	if err := binary.Read(pb.buf, binary.BigEndian, &strLen); err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Do not trust the length blindly
+	if int64(strLen) > int64(pb.buf.Len()) {
+		return nil, errShortPacket
 	}
 
 	v := make([]byte, strLen)
 	if err := binary.Read(pb.buf, binary.BigEndian, &v); err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(v), nil
+	return v, nil
+}
+
+func (pb *packetBuffer) readUTF8() (string, error) {
+	v, err := pb.readBytes()
+	return string(v), err
 }
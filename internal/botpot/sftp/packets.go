@@ -3,6 +3,7 @@ package sftp
 import (
 	"encoding/binary"
 	"errors"
+	"time"
 )
 
 const (
@@ -25,20 +26,27 @@ const (
 	sshFXPStat     = 17
 	sshFXPRename   = 18
 	sshFXPReadLink = 19
+	sshFXPSymlink  = 20 // Version 3
 	sshFXPLink     = 21
 	sshFXPBlock    = 22
 	sshFXPUnblock  = 23
 
-	sshFXPStatus = 101 // nolint:unused // RFC
-	sshFXPHandle = 102 // nolint:unused // RFC
-	sshFXPData   = 103 // nolint:unused // RFC
-	sshFXPName   = 104 // nolint:unused // RFC
-	sshFXPAttrs  = 105 // nolint:unused // RFC
+	sshFXPStatus = 101
+	sshFXPHandle = 102
+	sshFXPData   = 103
+	sshFXPName   = 104
+	sshFXPAttrs  = 105
 
 	sshFXPExtended      = 200
 	sshFXPExtendedReply = 201
 )
 
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02#section-5
+const (
+	sshFilexferAttrUIDGID    = 0x00000002
+	sshFilexferAttrACModTime = 0x00000008
+)
+
 // https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-7.1
 const (
 	sshFilexferAttrSize             = 0x00000001
@@ -79,12 +87,35 @@ type Version struct {
 	Version       uint32
 }
 
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (p *Version) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errShortPacket
+	}
+	p.Version = binary.BigEndian.Uint32(data)
+
+	pb := newPacketBuffer(data[4:])
+	for pb.remaining() > 0 {
+		name, err := pb.readBytes()
+		if err != nil {
+			return err
+		}
+		value, err := pb.readBytes()
+		if err != nil {
+			return err
+		}
+		p.ExtensionPair = append(p.ExtensionPair, name, value)
+	}
+	return nil
+}
+
 // Open SSH_FXP_OPEN C->S
 type Open struct {
 	Filename      string // UTF-8
 	Attrs         FileAttributes
-	Flags         uint32
-	DesiredAccess uint32
+	Flags         uint32 // pflags in version 3
+	DesiredAccess uint32 // Not set in version 3
+	version       uint32
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
@@ -95,8 +126,10 @@ func (p *Open) UnmarshalBinary(data []byte) error {
 	if p.Filename, err = pb.readUTF8(); err != nil {
 		return err
 	}
-	if p.DesiredAccess, err = pb.readUint32(); err != nil {
-		return err
+	if p.version > 3 {
+		if p.DesiredAccess, err = pb.readUint32(); err != nil {
+			return err
+		}
 	}
 	if p.Flags, err = pb.readUint32(); err != nil {
 		return err
@@ -104,6 +137,7 @@ func (p *Open) UnmarshalBinary(data []byte) error {
 
 	b := pb.getRemainingBytes()
 	if len(b) > 0 {
+		p.Attrs.version = p.version
 		return p.Attrs.UnmarshalBinary(b)
 	}
 	return nil
@@ -158,7 +192,7 @@ func (p *Read) UnmarshalBinary(data []byte) error {
 // Write SSH_FXP_WRITE C->S
 type Write struct {
 	Handle string
-	Data   string
+	Data   []byte
 	Offset uint64
 }
 
@@ -176,7 +210,7 @@ func (p *Write) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	p.Data, err = pb.readUTF8()
+	p.Data, err = pb.readBytes()
 	if err != nil {
 		return err
 	}
@@ -205,7 +239,7 @@ func (p *Remove) UnmarshalBinary(data []byte) error {
 type Rename struct {
 	OldPath string // UTF-8
 	NewPath string // UTF-8
-	Flags   uint32
+	Flags   uint32 // Not set in version 3
 }
 
 func (p *Rename) UnmarshalBinary(data []byte) error {
@@ -222,9 +256,11 @@ func (p *Rename) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	p.Flags, err = pb.readUint32()
-	if err != nil {
-		return err
+	if pb.remaining() > 0 {
+		p.Flags, err = pb.readUint32()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -232,8 +268,9 @@ func (p *Rename) UnmarshalBinary(data []byte) error {
 
 // Mkdir SSH_FXP_MKDIR C->S
 type Mkdir struct {
-	Path  string
-	Attrs FileAttributes
+	Path    string
+	Attrs   FileAttributes
+	version uint32
 }
 
 func (p *Mkdir) UnmarshalBinary(data []byte) error {
@@ -247,6 +284,7 @@ func (p *Mkdir) UnmarshalBinary(data []byte) error {
 
 	b := pb.getRemainingBytes()
 	if len(b) > 0 {
+		p.Attrs.version = p.version
 		return p.Attrs.UnmarshalBinary(b)
 	}
 	return nil
@@ -318,11 +356,13 @@ func (p *Stat) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	// 	p.Flags, err = pb.readUint32()
-	// 	if err != nil {
-	// 		fmt.Println("not working", p.Path)
-	// 		return err
-	// 	}
+	// Flags were added in version 4
+	if pb.remaining() > 0 {
+		p.Flags, err = pb.readUint32()
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -342,9 +382,12 @@ func (p *LStat) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	p.Flags, err = pb.readUint32()
-	if err != nil {
-		return err
+	// Flags were added in version 4
+	if pb.remaining() > 0 {
+		p.Flags, err = pb.readUint32()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -365,9 +408,12 @@ func (p *FStat) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	p.Flags, err = pb.readUint32()
-	if err != nil {
-		return err
+	// Flags were added in version 4
+	if pb.remaining() > 0 {
+		p.Flags, err = pb.readUint32()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -375,8 +421,9 @@ func (p *FStat) UnmarshalBinary(data []byte) error {
 
 // SetStat SSH_FXP_SETSTAT C->S
 type SetStat struct {
-	Path  string // UTF-8
-	Attrs FileAttributes
+	Path    string // UTF-8
+	Attrs   FileAttributes
+	version uint32
 }
 
 func (p *SetStat) UnmarshalBinary(data []byte) error {
@@ -390,6 +437,7 @@ func (p *SetStat) UnmarshalBinary(data []byte) error {
 
 	b := pb.getRemainingBytes()
 	if len(b) > 0 {
+		p.Attrs.version = p.version
 		return p.Attrs.UnmarshalBinary(b)
 	}
 	return nil
@@ -397,8 +445,9 @@ func (p *SetStat) UnmarshalBinary(data []byte) error {
 
 // FSetStat SSH_FXP_FSETSTAT C->S
 type FSetStat struct {
-	Handle string
-	Attrs  FileAttributes
+	Handle  string
+	Attrs   FileAttributes
+	version uint32
 }
 
 func (p *FSetStat) UnmarshalBinary(data []byte) error {
@@ -412,6 +461,7 @@ func (p *FSetStat) UnmarshalBinary(data []byte) error {
 
 	b := pb.getRemainingBytes()
 	if len(b) > 0 {
+		p.Attrs.version = p.version
 		return p.Attrs.UnmarshalBinary(b)
 	}
 	return nil
@@ -492,7 +542,36 @@ func (p *Link) UnmarshalBinary(data []byte) error {
 	if err != nil {
 		return err
 	}
-	// todo check symlink
+
+	p.SymLink, err = pb.readBool()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Symlink SSH_FXP_SYMLINK C->S, version 3
+type Symlink struct {
+	LinkPath   string // UTF-8
+	TargetPath string // UTF-8
+}
+
+func (p *Symlink) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	// OpenSSH sends the arguments in the reverse order of the draft
+	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+	p.TargetPath, err = pb.readUTF8()
+	if err != nil {
+		return err
+	}
+
+	p.LinkPath, err = pb.readUTF8()
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -509,7 +588,25 @@ type Block struct {
 	ULockMast uint32
 }
 
-func (p *Block) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Block) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	if p.Handle, err = pb.readUTF8(); err != nil {
+		return err
+	}
+	if p.Offset, err = pb.readUint64(); err != nil {
+		return err
+	}
+	if p.Length, err = pb.readUint64(); err != nil {
+		return err
+	}
+	if p.ULockMast, err = pb.readUint32(); err != nil {
+		return err
+	}
+
+	return nil
+}
 
 // Unblock SSH_FXP_UNBLOCK
 type Unblock struct {
@@ -521,7 +618,22 @@ type Unblock struct {
 	Length uint64
 }
 
-func (p *Unblock) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Unblock) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	if p.Handle, err = pb.readUTF8(); err != nil {
+		return err
+	}
+	if p.Offset, err = pb.readUint64(); err != nil {
+		return err
+	}
+	if p.Length, err = pb.readUint64(); err != nil {
+		return err
+	}
+
+	return nil
+}
 
 // Status SSH_FXP_STATUS S->C
 type Status struct {
@@ -530,39 +642,125 @@ type Status struct {
 	ErrorCode uint32
 }
 
-func (p *Status) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Status) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	if p.ErrorCode, err = pb.readUint32(); err != nil {
+		return err
+	}
+
+	// Some old servers only send the error code
+	if pb.remaining() == 0 {
+		return nil
+	}
+	if p.Message, err = pb.readUTF8(); err != nil {
+		return err
+	}
+	if pb.remaining() == 0 {
+		return nil
+	}
+	if p.LangTag, err = pb.readUTF8(); err != nil {
+		return err
+	}
+
+	return nil
+}
 
 // Handle SSH_FXP_HANDLE S->C
 type Handle struct {
 	Handle string
 }
 
-func (p *Handle) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Handle) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	p.Handle, err = pb.readUTF8()
+	return err
+}
 
 // Data SSH_FXP_DATA S->C
 type Data struct {
-	Data string
-	EOF  bool
+	Data []byte
+	EOF  bool // Optional, added in version 6
 }
 
-func (p *Data) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Data) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	if p.Data, err = pb.readBytes(); err != nil {
+		return err
+	}
+	if pb.remaining() > 0 {
+		if p.EOF, err = pb.readBool(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 // Name SSH_FXP_NAME S->C
 type Name struct {
-	Filename []string // Count times
-	Attrs    []byte   // Count times, Todo Attrs structure
+	Filename []string         // Count times
+	LongName []string         // Count times, only set in version 3
+	Attrs    []FileAttributes // Count times
 	Count    uint32
 	EOL      bool // Optional
+	version  uint32
 }
 
-func (p *Name) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Name) UnmarshalBinary(data []byte) error {
+	var err error
+	pb := newPacketBuffer(data)
+
+	if p.Count, err = pb.readUint32(); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < p.Count; i++ {
+		filename, err := pb.readUTF8()
+		if err != nil {
+			return err
+		}
+		p.Filename = append(p.Filename, filename)
+
+		if p.version <= 3 {
+			longName, err := pb.readUTF8()
+			if err != nil {
+				return err
+			}
+			p.LongName = append(p.LongName, longName)
+		}
+
+		attrs := FileAttributes{version: p.version}
+		if err = attrs.read(&pb); err != nil {
+			return err
+		}
+		p.Attrs = append(p.Attrs, attrs)
+	}
+
+	if pb.remaining() > 0 {
+		if p.EOL, err = pb.readBool(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 // Attrs SSH_FXP_ATTRS
 type Attrs struct {
-	Attrs []byte //  Todo Attrs structure
+	Attrs   FileAttributes
+	version uint32
 }
 
-func (p *Attrs) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *Attrs) UnmarshalBinary(data []byte) error {
+	p.Attrs.version = p.version
+	return p.Attrs.UnmarshalBinary(data)
+}
 
 // Extended SSH_FXP_EXTENDED
 type Extended struct {
@@ -578,6 +776,7 @@ func (p *Extended) UnmarshalBinary(data []byte) error {
 	if err != nil {
 		return err
 	}
+	p.ExtensionData = pb.getRemainingBytes()
 
 	return nil
 }
@@ -587,7 +786,10 @@ type ExtendedReply struct {
 	ExtensionData []byte
 }
 
-func (p *ExtendedReply) UnmarshalBinary(data []byte) error { return errors.New("not implemented") }
+func (p *ExtendedReply) UnmarshalBinary(data []byte) error {
+	p.ExtensionData = append([]byte(nil), data...)
+	return nil
+}
 
 // FileAttributes https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-7
 type FileAttributes struct {
@@ -614,19 +816,31 @@ type FileAttributes struct {
 	LinkCount          uint32
 	Flags              uint32
 	ExtendedCount      uint32
+	UID                uint32 // Version 3
+	GID                uint32 // Version 3
 	TextHint           byte
 	fType              byte
+	version            uint32
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
 func (fa *FileAttributes) UnmarshalBinary(data []byte) error {
 	pb := newPacketBuffer(data)
+	return fa.read(&pb)
+}
+
+// read reads the attributes from the packet buffer
+func (fa *FileAttributes) read(pb *packetBuffer) error {
 	var err error
 
 	if fa.Flags, err = pb.readUint32(); err != nil {
 		return err
 	}
 
+	if fa.version <= 3 {
+		return fa.readV3(pb)
+	}
+
 	if fa.fType, err = pb.readUint8(); err != nil {
 		return err
 	}
@@ -741,12 +955,64 @@ func (fa *FileAttributes) UnmarshalBinary(data []byte) error {
 		}
 	}
 
+	return fa.readExtended(pb)
+}
+
+// readV3 reads the attributes as they are defined in version 3
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02#section-5
+func (fa *FileAttributes) readV3(pb *packetBuffer) error {
+	var err error
+
+	if fa.Flags&sshFilexferAttrSize != 0 {
+		if fa.Size, err = pb.readUint64(); err != nil {
+			return err
+		}
+	}
+
+	if fa.Flags&sshFilexferAttrUIDGID != 0 {
+		if fa.UID, err = pb.readUint32(); err != nil {
+			return err
+		}
+		if fa.GID, err = pb.readUint32(); err != nil {
+			return err
+		}
+	}
+
+	if fa.Flags&sshFilexferAttrPermissions != 0 {
+		if fa.Permissions, err = pb.readUint32(); err != nil {
+			return err
+		}
+	}
+
+	if fa.Flags&sshFilexferAttrACModTime != 0 {
+		atime, err := pb.readUint32()
+		if err != nil {
+			return err
+		}
+		mtime, err := pb.readUint32()
+		if err != nil {
+			return err
+		}
+		fa.Atime, fa.MTime = int64(atime), int64(mtime)
+	}
+
+	return fa.readExtended(pb)
+}
+
+func (fa *FileAttributes) readExtended(pb *packetBuffer) error {
+	var err error
+
 	if fa.Flags&sshFilexferAttrExtended != 0 {
 		if fa.ExtendedCount, err = pb.readUint32(); err != nil {
 			return err
 		}
 	}
 
+	// Do not trust the count blindly
+	if int64(fa.ExtendedCount)*8 > int64(pb.remaining()) {
+		return errShortPacket
+	}
+
 	fa.ExtendedType = make([]string, fa.ExtendedCount)
 	fa.ExtendedData = make([]string, fa.ExtendedCount)
 
@@ -765,6 +1031,7 @@ func (fa *FileAttributes) UnmarshalBinary(data []byte) error {
 // packet represents an SFTP packet
 // https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-4
 type packet struct {
+	ts        time.Time // Not set unless known
 	data      []byte
 	length    uint32
 	pType     byte
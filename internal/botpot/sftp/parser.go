@@ -2,200 +2,561 @@ package sftp
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
-	"io"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
-// Parser can parse SFTP client messages
+// maxPacketLength is the largest packet accepted, same as OpenSSH
+const maxPacketLength = 256 * 1024
+
+// maxHole is the largest gap allowed between the end of a file
+// and the offset of a write, to not allocate absurd amounts of memory
+const maxHole = 16 * 1024 * 1024
+
+// maxFileSize and maxParsedSize limit how much data is kept of a single
+// file and of all files of a parser, data exceeding them is discarded
+const (
+	maxFileSize   = 64 * 1024 * 1024
+	maxParsedSize = 256 * 1024 * 1024
+)
+
+var errPacketTooLong = errors.New("packet too long")
+
+var packetTypes = map[byte]string{
+	sshFXPInit:          "init",
+	sshFXPVersion:       "version",
+	sshFXPOpen:          "open",
+	sshFXPClose:         "close",
+	sshFXPRead:          "read",
+	sshFXPWrite:         "write",
+	sshFXPLStat:         "lstat",
+	sshFXPFStat:         "fstat",
+	sshFXPSetStat:       "setstat",
+	sshFXPFsetStat:      "fsetstat",
+	sshFXPOpenDir:       "opendir",
+	sshFXPReadDir:       "readdir",
+	sshFXPRemove:        "remove",
+	sshFXPMkdir:         "mkdir",
+	sshFXPRmdir:         "rmdir",
+	sshFXPRealPath:      "realpath",
+	sshFXPStat:          "stat",
+	sshFXPRename:        "rename",
+	sshFXPReadLink:      "readlink",
+	sshFXPSymlink:       "symlink",
+	sshFXPLink:          "link",
+	sshFXPBlock:         "block",
+	sshFXPUnblock:       "unblock",
+	sshFXPStatus:        "status",
+	sshFXPHandle:        "handle",
+	sshFXPData:          "data",
+	sshFXPName:          "name",
+	sshFXPAttrs:         "attrs",
+	sshFXPExtended:      "extended",
+	sshFXPExtendedReply: "extended-reply",
+}
+
+// Operation is an SFTP request sent by the client along with the reply from the server
+type Operation struct {
+	RequestTS  time.Time
+	ReplyTS    time.Time
+	Request    any
+	Reply      any // nil if the server never replied
+	Attrs      *FileAttributes
+	Status     *Status
+	Type       string
+	Path       string
+	TargetPath string // Set for rename and links
+	Handle     string
+	Flags      uint32
+	RequestID  uint32
+}
+
+// File is a file transferred over SFTP
+type File struct {
+	Path     string
+	SHA256   string
+	Data     []byte
+	Upload   bool // false if the file was downloaded
+	Complete bool // false if data was discarded for exceeding the size limits
+}
+
+// Anomaly is something unexpected found in the SFTP data
+type Anomaly struct {
+	TS          time.Time
+	Description string
+	FromClient  bool
+}
+
+// transfer is a file being transferred through a handle
+type transfer struct {
+	path      string
+	data      []byte
+	written   bool
+	read      bool
+	truncated bool // true if data exceeding the size limits was discarded
+}
+
+// stream holds the data of one direction until a full packet has arrived
+type stream struct {
+	buf    bytes.Buffer
+	broken bool // true if the stream can no longer be parsed
+}
+
+// Parser parses the SFTP messages sent in both
+// directions of a channel as they arrive
 type Parser struct {
-	data *bytes.Buffer
-	l    zerolog.Logger
+	client     stream
+	server     stream
+	l          zerolog.Logger
+	pending    map[uint32]*Operation
+	handles    map[string]*transfer
+	Operations []*Operation
+	Files      []File
+	Anomalies  []Anomaly
+	size       int64 // Bytes held in all transfers
+	version    uint32
+	sync.Mutex
 }
 
-// NewParser creates a new SFTP server.
-func NewParser(buf []byte, logger zerolog.Logger) Parser {
-	return Parser{data: bytes.NewBuffer(buf), l: logger}
+// NewParser creates a new SFTP parser
+func NewParser(logger zerolog.Logger) *Parser {
+	return &Parser{
+		l:       logger,
+		pending: map[uint32]*Operation{},
+		handles: map[string]*transfer{},
+		version: 3,
+	}
 }
 
-// Parse parses the data provided
-func (s *Parser) Parse() error {
-	packets, err := s.readAllPackets()
-	if err != nil {
-		return err
+// Write feeds data sent at ts in one direction of the channel to the parser
+func (s *Parser) Write(fromClient bool, ts time.Time, data []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	st := s.stream(fromClient)
+	if st.broken {
+		return
 	}
-	err = s.parseInfo(packets)
-	if err != nil {
-		return err
+	st.buf.Write(data)
+
+	for {
+		p, ok, err := readPacket(&st.buf)
+		if err != nil {
+			// There is no way to know where the next packet starts
+			s.addAnomaly(fromClient, ts, fmt.Sprintf("unreadable packet, giving up on the stream: %s", err))
+			st.broken = true
+			st.buf.Reset()
+			return
+		}
+		if !ok {
+			return // Wait for more data
+		}
+
+		p.ts = ts
+		if fromClient {
+			err = s.parseRequest(p)
+		} else {
+			err = s.parseReply(p)
+		}
+		if err != nil {
+			s.addAnomaly(fromClient, ts, fmt.Sprintf("malformed %s packet: %s", packetName(p.pType), err))
+		}
 	}
+}
 
-	s.l.Debug().Int("count", len(packets)).Msg("Successfully parsed all SFTP packets")
+// Skip tells the parser that n bytes of one direction will never be written to it
+func (s *Parser) Skip(fromClient bool, ts time.Time, n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	st := s.stream(fromClient)
+	if st.broken {
+		return
+	}
+	s.addAnomaly(fromClient, ts, fmt.Sprintf("%d bytes were not captured, giving up on the stream", n))
+	st.broken = true
+	st.buf.Reset()
+}
+
+// Close finishes the parsing once there is no more data
+func (s *Parser) Close(ts time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, fromClient := range []bool{true, false} {
+		if st := s.stream(fromClient); st.buf.Len() > 0 {
+			s.addAnomaly(fromClient, ts, fmt.Sprintf("partial packet of %d bytes at disconnect", st.buf.Len()))
+			st.buf.Reset()
+		}
+	}
+
+	// Files that were never closed
+	for handle, t := range s.handles {
+		s.addFile(t)
+		delete(s.handles, handle)
+	}
+
+	s.l.Debug().
+		Int("operations", len(s.Operations)).
+		Int("files", len(s.Files)).
+		Int("anomalies", len(s.Anomalies)).
+		Msg("Finished parsing SFTP packets")
+}
+
+func (s *Parser) stream(fromClient bool) *stream {
+	if fromClient {
+		return &s.client
+	}
+	return &s.server
+}
+
+func (s *Parser) addAnomaly(fromClient bool, ts time.Time, description string) {
+	s.l.Warn().Bool("fromClient", fromClient).Str("anomaly", description).Msg("SFTP anomaly")
+	s.Anomalies = append(s.Anomalies, Anomaly{TS: ts, Description: description, FromClient: fromClient})
+}
+
+func packetName(pType byte) string {
+	if name, ok := packetTypes[pType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", pType)
+}
+
+// parseRequest parses a packet sent by the client
+func (s *Parser) parseRequest(packet packet) error {
+	op := &Operation{RequestID: packet.requestID, RequestTS: packet.ts, Type: packetTypes[packet.pType]}
+
+	switch packet.pType {
+	case sshFXPInit:
+		p := Init{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		s.version = p.Version
+		s.l.Debug().Interface("Init", p).Send()
+		return nil
+	case sshFXPOpen:
+		p := Open{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.Flags, op.Attrs = p, p.Filename, p.Flags, &p.Attrs
+	case sshFXPClose:
+		p := Close{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		if t, ok := s.handles[p.Handle]; ok {
+			s.addFile(t)
+			delete(s.handles, p.Handle)
+		}
+		op.Request, op.Handle = p, p.Handle
+	case sshFXPRead:
+		p := Read{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle = p, p.Handle
+	case sshFXPWrite:
+		p := Write{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		if t, ok := s.handles[p.Handle]; ok {
+			t.written = s.writeAt(t, true, packet.ts, p.Offset, p.Data) || t.written
+		}
+		p.Data = nil // Kept in the transfer
+		op.Request, op.Handle = p, p.Handle
+	case sshFXPMkdir:
+		p := Mkdir{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.Attrs = p, p.Path, &p.Attrs
+	case sshFXPRmdir:
+		p := Rmdir{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.Path
+	case sshFXPRemove:
+		p := Remove{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.Filename
+	case sshFXPRename:
+		p := Rename{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.TargetPath, op.Flags = p, p.OldPath, p.NewPath, p.Flags
+	case sshFXPOpenDir:
+		p := OpenDir{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.Path
+	case sshFXPReadDir:
+		p := ReadDir{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle = p, p.Handle
+	case sshFXPFsetStat:
+		p := FSetStat{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle, op.Attrs = p, p.Handle, &p.Attrs
+	case sshFXPRealPath:
+		p := RealPath{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.OriginalPath
+	case sshFXPStat:
+		p := Stat{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.Flags = p, p.Path, p.Flags
+	case sshFXPLStat:
+		p := LStat{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.Flags = p, p.Path, p.Flags
+	case sshFXPFStat:
+		p := FStat{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle, op.Flags = p, p.Handle, p.Flags
+	case sshFXPSetStat:
+		p := SetStat{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.Attrs = p, p.Path, &p.Attrs
+	case sshFXPReadLink:
+		p := ReadLink{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.Path
+	case sshFXPSymlink:
+		p := Symlink{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.TargetPath = p, p.LinkPath, p.TargetPath
+	case sshFXPLink:
+		p := Link{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path, op.TargetPath = p, p.NewLinkPath, p.ExistingLinkPath
+	case sshFXPBlock:
+		p := Block{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle, op.Flags = p, p.Handle, p.ULockMast
+	case sshFXPUnblock:
+		p := Unblock{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Handle = p, p.Handle
+	case sshFXPExtended:
+		p := Extended{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		op.Request, op.Path = p, p.ExtendedRequest
+	default:
+		s.l.Warn().Uint8("type", packet.pType).Msg("Did not understand SFTP packet")
+		return nil
+	}
+
+	s.l.Debug().Str("type", op.Type).Uint32("requestID", op.RequestID).Interface("request", op.Request).Send()
+	s.pending[op.RequestID] = op
+	s.Operations = append(s.Operations, op)
 	return nil
 }
 
-func (s *Parser) readAllPackets() ([]packet, error) {
-	var packets []packet
-	for s.data.Len() > 0 {
-		p, err := readPacket(s.data)
-		if err != nil {
-			return nil, err
+// parseReply parses a packet sent by the server
+// and attaches it to the request it replies to
+func (s *Parser) parseReply(packet packet) error {
+	var reply any
+	switch packet.pType {
+	case sshFXPVersion:
+		p := Version{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		if p.Version < s.version {
+			s.version = p.Version
+		}
+		s.l.Debug().Uint32("version", p.Version).Send()
+		return nil
+	case sshFXPStatus:
+		p := Status{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		reply = p
+	case sshFXPHandle:
+		p := Handle{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		reply = p
+	case sshFXPData:
+		p := Data{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		reply = p
+	case sshFXPName:
+		p := Name{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		reply = p
+	case sshFXPAttrs:
+		p := Attrs{version: s.version}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
+		}
+		reply = p
+	case sshFXPExtendedReply:
+		p := ExtendedReply{}
+		if err := p.UnmarshalBinary(packet.data); err != nil {
+			return err
 		}
-		packets = append(packets, p)
+		reply = p
+	default:
+		s.l.Warn().Uint8("type", packet.pType).Msg("Did not understand SFTP packet")
+		return nil
 	}
 
-	return packets, nil
+	op, ok := s.pending[packet.requestID]
+	if !ok {
+		s.l.Warn().Uint32("requestID", packet.requestID).Msg("Got SFTP reply to unknown request")
+		return nil
+	}
+	delete(s.pending, packet.requestID)
+
+	switch v := reply.(type) {
+	case Status:
+		op.Status = &v
+	case Handle:
+		op.Handle = v.Handle
+		if open, ok := op.Request.(Open); ok {
+			s.handles[v.Handle] = &transfer{path: open.Filename}
+		}
+	case Data:
+		read, isRead := op.Request.(Read)
+		if t, ok := s.handles[read.Handle]; ok && isRead {
+			t.read = s.writeAt(t, false, packet.ts, read.Offset, v.Data) || t.read
+		}
+		v.Data = nil // Kept in the transfer
+		reply = v
+	case Attrs:
+		op.Attrs = &v.Attrs
+	}
+	op.Reply, op.ReplyTS = reply, packet.ts
+	return nil
 }
 
-func (s *Parser) parseInfo(packets []packet) error {
-	for _, packet := range packets {
-		switch packet.pType {
-		case sshFXPInit:
-			p := Init{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Init", p).Send()
-		case sshFXPOpen:
-			p := Open{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Open", p).Send()
-		case sshFXPClose:
-			p := Close{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Close", p).Send()
-		case sshFXPRead:
-			p := Read{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Read", p).Send()
-		case sshFXPWrite:
-			p := Write{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			p.Data = "notset"
-			s.l.Debug().Interface("Write", p).Send()
-		case sshFXPMkdir:
-			p := Mkdir{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Mkdir", p).Send()
-		case sshFXPOpenDir:
-			p := OpenDir{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("OpenDir", p).Send()
-		case sshFXPReadDir:
-			p := ReadDir{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("ReadDir", p).Send()
-		case sshFXPFsetStat:
-			p := FSetStat{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("FsetStat", p).Send()
-		case sshFXPRealPath:
-			p := RealPath{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("RealPath", p).Send()
-		case sshFXPStat:
-			p := Stat{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Stat", p).Send()
-		case sshFXPLStat:
-			p := LStat{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("LStat", p).Send()
-		case sshFXPFStat:
-			p := FStat{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("FStat", p).Send()
-		case sshFXPSetStat:
-			p := SetStat{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("SetStat", p).Send()
-		case sshFXPReadLink:
-			p := ReadLink{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("ReadLink", p).Send()
-		case sshFXPLink:
-			p := Link{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
-			}
-			s.l.Debug().Interface("Link", p).Send()
-		case sshFXPExtended:
-			p := Extended{}
-			if err := p.UnmarshalBinary(packet.data); err != nil {
-				return err
+// writeAt writes data at the offset of the transfer. Data that would make
+// the transfer exceed the size limits is discarded, which is reported once.
+func (s *Parser) writeAt(t *transfer, fromClient bool, ts time.Time, offset uint64, data []byte) bool {
+	if offset > uint64(len(t.data))+maxHole {
+		s.l.Warn().Str("path", t.path).Uint64("offset", offset).Msg("Ignoring SFTP data at unreasonable offset")
+		return false
+	}
+
+	end := offset + uint64(len(data))
+	if end > uint64(len(t.data)) {
+		grow := int64(end) - int64(len(t.data))
+		if end > maxFileSize || s.size+grow > maxParsedSize {
+			if !t.truncated {
+				t.truncated = true
+				s.addAnomaly(fromClient, ts, fmt.Sprintf("%s exceeds the size limits, only %d bytes are kept", t.path, len(t.data)))
 			}
-			s.l.Debug().Interface("Extended", p).Send()
-		default:
-			s.l.Warn().Uint8("type", packet.pType).Msg("Did not understand SFTP packet")
+			return true
 		}
+		t.data = append(t.data, make([]byte, grow)...)
+		s.size += grow
 	}
-	return nil
+	copy(t.data[offset:], data)
+	return true
 }
 
-// readPacket reads a single SFTP packet
-func readPacket(r io.Reader) (packet, error) {
-	// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-4
-	var len uint32
-	err := binary.Read(r, binary.BigEndian, &len)
-	if err != nil {
-		return packet{}, err
+func (s *Parser) addFile(t *transfer) {
+	if !t.written && !t.read {
+		return
 	}
 
-	var readBytes uint32 = 1
+	sum := sha256.Sum256(t.data)
+	f := File{
+		Path:     t.path,
+		SHA256:   hex.EncodeToString(sum[:]),
+		Data:     t.data,
+		Upload:   t.written,
+		Complete: !t.truncated,
+	}
+	s.l.Info().Str("path", f.Path).Int("size", len(f.Data)).Str("sha256", f.SHA256).Bool("upload", f.Upload).
+		Msg("Reconstructed SFTP file")
+	s.Files = append(s.Files, f)
+}
 
-	var pType byte
-	err = binary.Read(r, binary.BigEndian, &pType)
-	if err != nil {
-		return packet{}, err
+// readPacket reads a single SFTP packet from the buffer. If the
+// buffer does not yet hold a full packet, nothing is consumed.
+func readPacket(buf *bytes.Buffer) (packet, bool, error) {
+	// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-4
+	b := buf.Bytes()
+	if len(b) < 5 {
+		return packet{}, false, nil
 	}
 
-	var requestID uint32
+	len := binary.BigEndian.Uint32(b)
+	if len > maxPacketLength {
+		return packet{}, false, errPacketTooLong
+	}
+
+	pType := b[4]
+	var headerLen uint32 = 1
 	if pType != sshFXPInit && pType != sshFXPVersion {
-		readBytes += 4
-		err = binary.Read(r, binary.BigEndian, &requestID)
-		if err != nil {
-			return packet{}, err
-		}
+		headerLen += 4
+	}
+	if len < headerLen {
+		return packet{}, false, fmt.Errorf("invalid packet length %d", len)
+	}
+	if uint32(buf.Len()) < 4+len {
+		return packet{}, false, nil
 	}
 
-	buf := make([]byte, len-readBytes)
-	err = binary.Read(r, binary.BigEndian, buf)
-	if err != nil {
-		return packet{}, err
+	buf.Next(5)
+	var requestID uint32
+	if headerLen == 5 {
+		requestID = binary.BigEndian.Uint32(buf.Next(4))
 	}
 
 	return packet{
-		data:      buf,
+		data:      append([]byte(nil), buf.Next(int(len-headerLen))...),
 		length:    len,
 		pType:     pType,
 		requestID: requestID,
-	}, nil
+	}, true, nil
 }
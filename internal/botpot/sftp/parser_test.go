@@ -0,0 +1,268 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// chunk is data sent in one direction of the channel
+type chunk struct {
+	data       []byte
+	fromClient bool
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func str(s string) []byte {
+	return append(u32(uint32(len(s))), s...)
+}
+
+// pkt encodes a packet, init and version packets have no request ID
+func pkt(pType byte, id uint32, fields ...[]byte) []byte {
+	body := []byte{pType}
+	if pType != sshFXPInit && pType != sshFXPVersion {
+		body = append(body, u32(id)...)
+	}
+	for _, f := range fields {
+		body = append(body, f...)
+	}
+	return append(u32(uint32(len(body))), body...)
+}
+
+func client(packets ...[]byte) chunk {
+	return chunk{data: bytes.Join(packets, nil), fromClient: true}
+}
+
+func server(packets ...[]byte) chunk {
+	return chunk{data: bytes.Join(packets, nil)}
+}
+
+// split sends the chunks one byte at a time
+func split(chunks []chunk) []chunk {
+	res := []chunk{}
+	for _, c := range chunks {
+		for i := range c.data {
+			res = append(res, chunk{data: c.data[i : i+1], fromClient: c.fromClient})
+		}
+	}
+	return res
+}
+
+var (
+	initPkt    = pkt(sshFXPInit, 0, u32(3))
+	versionPkt = pkt(sshFXPVersion, 0, u32(3))
+	statusOK   = func(id uint32) []byte { return pkt(sshFXPStatus, id, u32(0), str(""), str("")) }
+)
+
+func TestParser(t *testing.T) {
+	upload := []chunk{
+		client(initPkt), server(versionPkt),
+		client(pkt(sshFXPOpen, 1, str("/tmp/x"), u32(0x1a), u32(0))),
+		server(pkt(sshFXPHandle, 1, str("h"))),
+		client(pkt(sshFXPWrite, 2, str("h"), u64(0), str("hello"))),
+		client(pkt(sshFXPWrite, 3, str("h"), u64(5), str(" world"))),
+		server(statusOK(2), statusOK(3)),
+		client(pkt(sshFXPClose, 4, str("h"))),
+		server(statusOK(4)),
+	}
+
+	tests := []struct {
+		name      string
+		chunks    []chunk
+		files     []File
+		anomalies []string // Substrings of the anomalies, in order
+		statuses  map[string]uint32
+	}{
+		{
+			name:   "upload",
+			chunks: upload,
+			files:  []File{{Path: "/tmp/x", Data: []byte("hello world"), Upload: true, Complete: true}},
+		},
+		{
+			name:   "upload split into single bytes",
+			chunks: split(upload),
+			files:  []File{{Path: "/tmp/x", Data: []byte("hello world"), Upload: true, Complete: true}},
+		},
+		{
+			name: "download",
+			chunks: []chunk{
+				client(pkt(sshFXPOpen, 1, str("/etc/passwd"), u32(1), u32(0))),
+				server(pkt(sshFXPHandle, 1, str("h"))),
+				client(pkt(sshFXPRead, 2, str("h"), u64(0), u32(32768))),
+				server(pkt(sshFXPData, 2, str("root:x:0:0"))),
+				client(pkt(sshFXPClose, 3, str("h"))),
+			},
+			files: []File{{Path: "/etc/passwd", Data: []byte("root:x:0:0"), Complete: true}},
+		},
+		{
+			name: "file never closed",
+			chunks: []chunk{
+				client(pkt(sshFXPOpen, 1, str("/tmp/y"), u32(0x1a), u32(0))),
+				server(pkt(sshFXPHandle, 1, str("h"))),
+				client(pkt(sshFXPWrite, 2, str("h"), u64(0), str("abc"))),
+			},
+			files: []File{{Path: "/tmp/y", Data: []byte("abc"), Upload: true, Complete: true}},
+		},
+		{
+			name: "opened but not transferred",
+			chunks: []chunk{
+				client(pkt(sshFXPOpen, 1, str("/tmp/z"), u32(1), u32(0))),
+				server(pkt(sshFXPHandle, 1, str("h"))),
+				client(pkt(sshFXPClose, 2, str("h"))),
+			},
+		},
+		{
+			name: "out of order replies",
+			chunks: []chunk{
+				client(pkt(sshFXPStat, 1, str("/a")), pkt(sshFXPStat, 2, str("/b"))),
+				server(pkt(sshFXPStatus, 2, u32(2), str("no such file"), str(""))),
+				server(pkt(sshFXPStatus, 1, u32(3))),
+			},
+			statuses: map[string]uint32{"/a": 3, "/b": 2},
+		},
+		{
+			name: "write to unknown handle",
+			chunks: []chunk{
+				client(pkt(sshFXPWrite, 1, str("nope"), u64(0), str("abc"))),
+			},
+		},
+		{
+			name:   "reply to unknown request",
+			chunks: []chunk{server(statusOK(7))},
+		},
+		{
+			name: "malformed packet",
+			chunks: []chunk{
+				client(pkt(sshFXPOpen, 1, str("/tmp/x"))),
+				client(pkt(sshFXPRemove, 2, str("/tmp/x"))),
+			},
+			anomalies: []string{"malformed open packet"},
+		},
+		{
+			name: "packet too long",
+			chunks: []chunk{
+				client(append(u32(maxPacketLength+1), sshFXPWrite)),
+				client(pkt(sshFXPRemove, 2, str("/tmp/x"))),
+			},
+			anomalies: []string{"unreadable packet"},
+		},
+		{
+			name:      "packet too short for its header",
+			chunks:    []chunk{client(append(u32(1), sshFXPRemove))},
+			anomalies: []string{"invalid packet length 1"},
+		},
+		{
+			name:      "partial packet at disconnect",
+			chunks:    []chunk{client(pkt(sshFXPRemove, 1, str("/tmp/x"))[:9])},
+			anomalies: []string{"partial packet of 9 bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(zerolog.Nop())
+			for _, c := range tt.chunks {
+				p.Write(c.fromClient, time.Now(), c.data)
+			}
+			p.Close(time.Now())
+
+			if len(p.Files) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(p.Files), len(tt.files))
+			}
+			for i, want := range tt.files {
+				got := p.Files[i]
+				if got.Path != want.Path || !bytes.Equal(got.Data, want.Data) || got.Upload != want.Upload || got.Complete != want.Complete {
+					t.Errorf("got file %q %q upload=%v complete=%v, want %q %q upload=%v complete=%v",
+						got.Path, got.Data, got.Upload, got.Complete, want.Path, want.Data, want.Upload, want.Complete)
+				}
+			}
+
+			checkAnomalies(t, p.Anomalies, tt.anomalies)
+
+			for _, op := range p.Operations {
+				want, ok := tt.statuses[op.Path]
+				if !ok {
+					continue
+				}
+				if op.Status == nil || op.Status.ErrorCode != want {
+					t.Errorf("got status %+v for %s, want %d", op.Status, op.Path, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParserIgnoresStreamAfterSkip(t *testing.T) {
+	p := NewParser(zerolog.Nop())
+	p.Skip(true, time.Now(), 10)
+	p.Write(true, time.Now(), pkt(sshFXPRemove, 1, str("/tmp/x")))
+	p.Close(time.Now())
+
+	if len(p.Operations) != 0 {
+		t.Errorf("got %d operations after skipped data, want 0", len(p.Operations))
+	}
+	checkAnomalies(t, p.Anomalies, []string{"10 bytes were not captured"})
+}
+
+func TestWriteAtLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileSize  int
+		held      int64 // Bytes held by other transfers
+		offset    uint64
+		data      string
+		want      int
+		truncated bool
+	}{
+		{name: "append", fileSize: 3, offset: 3, data: "abc", want: 6},
+		{name: "overwrite", fileSize: 3, offset: 0, data: "ab", want: 3},
+		{name: "hole", fileSize: 0, offset: 10, data: "a", want: 11},
+		{name: "unreasonable offset", fileSize: 0, offset: maxHole + 1, data: "a", want: 0},
+		{name: "file too large", fileSize: maxFileSize - 1, offset: maxFileSize - 1, data: "ab", want: maxFileSize - 1, truncated: true},
+		{name: "parser full", fileSize: 1, held: maxParsedSize - 1, offset: 1, data: "ab", want: 1, truncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(zerolog.Nop())
+			p.size = tt.held + int64(tt.fileSize)
+			tr := &transfer{path: "/tmp/x", data: make([]byte, tt.fileSize)}
+
+			p.writeAt(tr, true, time.Now(), tt.offset, []byte(tt.data))
+			if len(tr.data) != tt.want {
+				t.Errorf("got %d bytes, want %d", len(tr.data), tt.want)
+			}
+			if tr.truncated != tt.truncated {
+				t.Errorf("got truncated %v, want %v", tr.truncated, tt.truncated)
+			}
+			if tt.truncated {
+				// Only reported once
+				p.writeAt(tr, true, time.Now(), tt.offset, []byte(tt.data))
+				checkAnomalies(t, p.Anomalies, []string{"exceeds the size limits"})
+			}
+		})
+	}
+}
+
+func checkAnomalies(t *testing.T, got []Anomaly, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got anomalies %+v, want %q", got, want)
+	}
+	for i, w := range want {
+		if !strings.Contains(got[i].Description, w) {
+			t.Errorf("got anomaly %q, want it to contain %q", got[i].Description, w)
+		}
+	}
+}
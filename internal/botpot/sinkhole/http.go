@@ -0,0 +1,144 @@
+package sinkhole
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// maxBodySize is the largest request body stored
+const maxBodySize = 1024 * 1024
+
+// Response is what the HTTP sinkhole replies with
+type Response struct {
+	Headers http.Header
+	Body    string
+	Status  int
+}
+
+// HTTP is a fake web server that stores every
+// request sent to it and replies with a fixed response
+type HTTP struct {
+	db       db.Store
+	response Response
+}
+
+// NewHTTP creates a new HTTP sinkhole
+func NewHTTP(database db.Store, response Response) *HTTP {
+	return &HTTP{db: database, response: response}
+}
+
+// HTTPRequest is a request sent to the HTTP sinkhole
+type HTTPRequest struct {
+	TS      time.Time
+	Headers http.Header
+	Method  string
+	Host    string
+	URI     string
+	Proto   string
+	Body    []byte
+}
+
+// Insert tries to insert the request into the database
+func (r *HTTPRequest) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO SinkholeHTTPRequest(session_id, channel_id, ts, method, host, uri, proto, headers, body)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, sessionID, channelID, r.TS, db.Sanitize(r.Method), db.Sanitize(r.Host), db.Sanitize(r.URI), db.Sanitize(r.Proto),
+		r.Headers, r.Body)
+	return err
+}
+
+// ServeConn serves a single connection, blocking until it is closed
+func (h *HTTP) ServeConn(conn net.Conn, sessionID int, channelID uint32) {
+	l := log.With().Str("sinkhole", "http").Int("sessionID", sessionID).Uint32("chID", channelID).Logger()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			l.Debug().Err(err).Msg("Could not read request body")
+		}
+
+		req := HTTPRequest{
+			TS:      time.Now(),
+			Headers: r.Header,
+			Method:  r.Method,
+			Host:    r.Host,
+			URI:     r.RequestURI,
+			Proto:   r.Proto,
+			Body:    body,
+		}
+		l.Info().Str("method", req.Method).Str("host", req.Host).Str("uri", req.URI).Msg("HTTP request captured")
+		if err = h.db.BeginTx(func(tx pgx.Tx) error { return req.Insert(tx, sessionID, channelID) }); err != nil {
+			l.Err(err).Msg("Could not insert HTTP request into DB")
+		}
+
+		for k, v := range h.response.Headers {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.response.Body)))
+		w.WriteHeader(h.response.Status)
+		if _, err = io.WriteString(w, h.response.Body); err != nil {
+			l.Debug().Err(err).Msg("Could not write response")
+		}
+	})
+
+	l.Debug().Msg("Serving HTTP connection")
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Minute,
+		IdleTimeout:       time.Minute,
+	}
+	ln := newConnListener(conn)
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed || state == http.StateHijacked {
+			// nolint:errcheck // never fails
+			ln.Close()
+		}
+	}
+
+	// nolint:errcheck // returns net.ErrClosed once the connection has been closed
+	server.Serve(ln)
+}
+
+// connListener is a listener that accepts a single connection
+type connListener struct {
+	conn      net.Conn
+	done      chan struct{}
+	accepted  sync.Once
+	closeOnce sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, done: make(chan struct{})}
+}
+
+// Accept implements the net.Listener interface
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.accepted.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+// Close implements the net.Listener interface
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr implements the net.Listener interface
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
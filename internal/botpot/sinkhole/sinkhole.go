@@ -0,0 +1,3 @@
+// Package sinkhole provides fake services that forwarded
+// connections can be terminated into instead of being relayed
+package sinkhole
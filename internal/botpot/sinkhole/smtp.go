@@ -0,0 +1,287 @@
+package sinkhole
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxLineLength is the longest command line accepted - RFC 5321 4.5.3.1.4
+	maxLineLength = 512
+	// smtpTimeout is how long to wait for the next command, RFC 5321 4.5.3.2.7
+	smtpTimeout = 5 * time.Minute
+)
+
+// SMTP is a fake mail server that accepts every
+// message sent to it and stores it without relaying it
+type SMTP struct {
+	db       db.Store
+	hostname string
+	maxSize  int64
+}
+
+// NewSMTP creates a new SMTP sinkhole which introduces itself as
+// hostname and accepts messages of up to maxSize bytes
+func NewSMTP(database db.Store, hostname string, maxSize int64) *SMTP {
+	return &SMTP{db: database, hostname: hostname, maxSize: maxSize}
+}
+
+// Mail is a message sent to the SMTP sinkhole
+type Mail struct {
+	TS           time.Time
+	Helo         string
+	AuthUser     string
+	AuthPassword string
+	From         string
+	To           []string
+	Data         []byte
+}
+
+// Insert tries to insert the mail into the database
+func (m *Mail) Insert(tx pgx.Tx, sessionID int, channelID uint32) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO SinkholeMail(session_id, channel_id, ts, helo, auth_user, auth_password, mail_from, rcpt_to, data)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, sessionID, channelID, m.TS, db.Sanitize(m.Helo), db.Sanitize(m.AuthUser), db.Sanitize(m.AuthPassword),
+		db.Sanitize(m.From), db.SanitizeAll(m.To), m.Data)
+	return err
+}
+
+// smtpConn is the state of a single SMTP connection
+type smtpConn struct {
+	s    *SMTP
+	conn net.Conn
+	r    *bufio.Reader
+	l    zerolog.Logger
+	mail Mail
+	// Kept across messages of the same connection
+	helo, authUser, authPassword string
+	sessionID                    int
+	channelID                    uint32
+}
+
+// ServeConn serves a single connection, blocking until it is closed
+func (s *SMTP) ServeConn(conn net.Conn, sessionID int, channelID uint32) {
+	c := &smtpConn{
+		s:         s,
+		conn:      conn,
+		r:         bufio.NewReaderSize(conn, maxLineLength),
+		l:         log.With().Str("sinkhole", "smtp").Int("sessionID", sessionID).Uint32("chID", channelID).Logger(),
+		sessionID: sessionID,
+		channelID: channelID,
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.l.Err(err).Msg("Could not close connection")
+		}
+	}()
+
+	if err := c.serve(); err != nil && err != io.EOF {
+		c.l.Debug().Err(err).Msg("SMTP connection ended")
+	}
+}
+
+func (c *smtpConn) serve() error {
+	if err := c.reply("220 %s ESMTP Postfix (Ubuntu)", c.s.hostname); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			c.helo = arg
+			err = c.reply("250 %s", c.s.hostname)
+		case "EHLO":
+			c.helo = arg
+			err = c.reply("250-%s\r\n250-PIPELINING\r\n250-SIZE %d\r\n250-AUTH PLAIN LOGIN\r\n250-8BITMIME\r\n250 SMTPUTF8",
+				c.s.hostname, c.s.maxSize)
+		case "AUTH":
+			err = c.auth(arg)
+		case "MAIL":
+			c.mail = Mail{From: address(arg)}
+			err = c.reply("250 2.1.0 Ok")
+		case "RCPT":
+			c.mail.To = append(c.mail.To, address(arg))
+			err = c.reply("250 2.1.5 Ok")
+		case "DATA":
+			err = c.data()
+		case "RSET":
+			c.mail = Mail{}
+			err = c.reply("250 2.0.0 Ok")
+		case "NOOP":
+			err = c.reply("250 2.0.0 Ok")
+		case "VRFY":
+			err = c.reply("252 2.0.0 %s", arg)
+		case "QUIT":
+			// nolint:errcheck // closing anyway
+			c.reply("221 2.0.0 Bye")
+			return nil
+		default:
+			err = c.reply("502 5.5.2 Error: command not recognized")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// auth accepts any credentials - RFC 4954
+func (c *smtpConn) auth(arg string) error {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		// RFC 4616, authzid NUL authcid NUL passwd
+		if initial == "" {
+			if err := c.reply("334 "); err != nil {
+				return err
+			}
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			initial = line
+		}
+		parts := strings.SplitN(decodeBase64(initial), "\x00", 3)
+		if len(parts) == 3 {
+			c.authUser, c.authPassword = parts[1], parts[2]
+		}
+	case "LOGIN":
+		if initial == "" {
+			if err := c.reply("334 VXNlcm5hbWU6"); err != nil { // Username:
+				return err
+			}
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			initial = line
+		}
+		c.authUser = decodeBase64(initial)
+		if err := c.reply("334 UGFzc3dvcmQ6"); err != nil { // Password:
+			return err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		c.authPassword = decodeBase64(line)
+	default:
+		return c.reply("504 5.5.4 Unrecognized authentication type")
+	}
+
+	c.l.Info().Str("user", c.authUser).Str("password", c.authPassword).Msg("SMTP authentication")
+	return c.reply("235 2.7.0 Authentication successful")
+}
+
+// data reads a message until the terminating dot - RFC 5321 4.1.1.4
+func (c *smtpConn) data() error {
+	if len(c.mail.To) == 0 {
+		return c.reply("503 5.5.1 Error: need RCPT command")
+	}
+	if err := c.reply("354 End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+
+	data := new(bytes.Buffer)
+	tooLarge := false
+	lineStart := true // false while reading the rest of a line longer than the buffer
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(smtpTimeout)); err != nil {
+			return err
+		}
+		line, err := c.r.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+		if lineStart {
+			if bytes.Equal(line, []byte(".\r\n")) || bytes.Equal(line, []byte(".\n")) {
+				break
+			}
+			// Dot-stuffing - RFC 5321 4.5.2
+			if bytes.HasPrefix(line, []byte(".")) {
+				line = line[1:]
+			}
+		}
+		lineStart = err == nil
+		if c.s.maxSize > 0 && int64(data.Len()+len(line)) > c.s.maxSize {
+			tooLarge = true
+			continue
+		}
+		data.Write(line)
+	}
+
+	c.mail.TS = time.Now()
+	c.mail.Helo, c.mail.AuthUser, c.mail.AuthPassword = c.helo, c.authUser, c.authPassword
+	c.mail.Data = data.Bytes()
+	c.l.Info().Str("from", c.mail.From).Strs("to", c.mail.To).Int("size", data.Len()).Bool("tooLarge", tooLarge).
+		Msg("Mail captured")
+
+	mail := c.mail
+	if err := c.s.db.BeginTx(func(tx pgx.Tx) error { return mail.Insert(tx, c.sessionID, c.channelID) }); err != nil {
+		c.l.Err(err).Msg("Could not insert mail into DB")
+	}
+	c.mail = Mail{}
+
+	if tooLarge {
+		return c.reply("552 5.3.4 Error: message file too big")
+	}
+	return c.reply("250 2.0.0 Ok: queued as %X", mail.TS.UnixNano()&0xFFFFFFFFFF)
+}
+
+// readLine reads a command line without its line ending
+func (c *smtpConn) readLine() (string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line too long")
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (c *smtpConn) reply(format string, args ...any) error {
+	_, err := fmt.Fprintf(c.conn, format+"\r\n", args...)
+	return err
+}
+
+// address returns the address of a MAIL FROM:<...> or RCPT TO:<...> argument
+func address(arg string) string {
+	_, addr, ok := strings.Cut(arg, ":")
+	if !ok {
+		return arg
+	}
+	addr = strings.TrimSpace(addr)
+	if i := strings.IndexByte(addr, '>'); strings.HasPrefix(addr, "<") && i > 0 {
+		return addr[1:i]
+	}
+	return addr
+}
+
+func decodeBase64(s string) string {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"sync"
+
+	"github.com/alx99/botpot/internal/botpot/ssh/session"
+	"golang.org/x/crypto/ssh"
+)
+
+// authLog keeps track of the authentication attempts of
+// connections that have not yet finished the handshake
+type authLog struct {
+	attempts map[string][]session.AuthAttempt
+	sync.Mutex
+}
+
+func newAuthLog() authLog {
+	return authLog{attempts: make(map[string][]session.AuthAttempt)}
+}
+
+// next returns the number of the next authentication attempt of the connection
+func (a *authLog) next(conn ssh.ConnMetadata) int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.attempts[conn.RemoteAddr().String()]) + 1
+}
+
+// add records an authentication attempt
+func (a *authLog) add(conn ssh.ConnMetadata, attempt session.AuthAttempt) {
+	a.Lock()
+	defer a.Unlock()
+
+	key := conn.RemoteAddr().String()
+	a.attempts[key] = append(a.attempts[key], attempt)
+}
+
+// pop returns and forgets all the attempts made by the remote address
+func (a *authLog) pop(rAddr string) []session.AuthAttempt {
+	a.Lock()
+	defer a.Unlock()
+
+	attempts := a.attempts[rAddr]
+	delete(a.attempts, rAddr)
+	return attempts
+}
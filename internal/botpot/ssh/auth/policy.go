@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods
+const (
+	MethodPassword            = "password"
+	MethodPublicKey           = "publickey"
+	MethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// Attempt describes an authentication attempt made by a client
+type Attempt struct {
+	User     string
+	Password string // Not set for public key attempts
+	Method   string
+	Number   int // Starts at 1 for every connection
+}
+
+// Policy decides whether an authentication attempt should succeed
+type Policy interface {
+	Accept(a Attempt) bool
+}
+
+// NewPolicy creates a policy that accepts an attempt only if all of the
+// configured rules accept it. Zero values disable the respective rule,
+// except for the probability where 0 denies and 1 accepts every attempt.
+// nolint:ireturn // returns one of the policies in this package
+func NewPolicy(acceptAfter int, wordlistPath string, denyUsers []string, probability float64) (Policy, error) {
+	if !(probability >= 0 && probability <= 1) {
+		return nil, fmt.Errorf("probability %v is not between 0 and 1", probability)
+	}
+	policies := All{}
+
+	if len(denyUsers) > 0 {
+		policies = append(policies, NewDenyUsers(denyUsers))
+	}
+	if acceptAfter > 0 {
+		policies = append(policies, AcceptAfter(acceptAfter))
+	}
+	if wordlistPath != "" {
+		w, err := ReadWordlist(wordlistPath)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, w)
+	}
+	if probability < 1 {
+		policies = append(policies, NewProbability(probability))
+	}
+
+	if len(policies) == 0 {
+		return AcceptAll{}, nil
+	}
+	return policies, nil
+}
+
+// AcceptAll accepts every attempt
+type AcceptAll struct{}
+
+// Accept implements Policy
+func (AcceptAll) Accept(Attempt) bool { return true }
+
+// All accepts an attempt if all of its policies accept it
+type All []Policy
+
+// Accept implements Policy
+func (all All) Accept(a Attempt) bool {
+	for _, p := range all {
+		if !p.Accept(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// AcceptAfter accepts an attempt only after N attempts have failed
+type AcceptAfter int
+
+// Accept implements Policy
+func (n AcceptAfter) Accept(a Attempt) bool {
+	return a.Number > int(n)
+}
+
+// DenyUsers rejects all attempts made with specific usernames
+type DenyUsers map[string]struct{}
+
+// NewDenyUsers creates a new DenyUsers policy
+func NewDenyUsers(users []string) DenyUsers {
+	d := DenyUsers{}
+	for _, u := range users {
+		if u = strings.TrimSpace(u); u != "" {
+			d[u] = struct{}{}
+		}
+	}
+	return d
+}
+
+// Accept implements Policy
+func (d DenyUsers) Accept(a Attempt) bool {
+	_, found := d[a.User]
+	return !found
+}
+
+// Wordlist accepts only the credentials found in a wordlist
+type Wordlist struct {
+	creds     map[string]struct{} // user:password
+	passwords map[string]struct{} // password valid for any user
+}
+
+// ReadWordlist reads a wordlist from a file. Every line is either on the
+// form user:password, or only contains a password valid for any user.
+func ReadWordlist(path string) (Wordlist, error) {
+	w := Wordlist{creds: map[string]struct{}{}, passwords: map[string]struct{}{}}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return w, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, ":") {
+			w.creds[line] = struct{}{}
+		} else {
+			w.passwords[line] = struct{}{}
+		}
+	}
+
+	return w, scanner.Err()
+}
+
+// Accept implements Policy
+func (w Wordlist) Accept(a Attempt) bool {
+	if a.Method == MethodPublicKey {
+		return false
+	}
+	if _, found := w.passwords[a.Password]; found {
+		return true
+	}
+	_, found := w.creds[a.User+":"+a.Password]
+	return found
+}
+
+// Probability accepts attempts with a given probability
+type Probability struct {
+	rand *rand.Rand
+	p    float64
+	sync.Mutex
+}
+
+// NewProbability creates a new Probability policy
+func NewProbability(p float64) *Probability {
+	return &Probability{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())), // nolint:gosec // not used for crypto
+		p:    p,
+	}
+}
+
+// Accept implements Policy
+func (p *Probability) Accept(Attempt) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.rand.Float64() < p.p
+}
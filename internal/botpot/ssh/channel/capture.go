@@ -0,0 +1,206 @@
+package channel
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/event"
+)
+
+// streamParser parses the data of a channel as it is captured
+type streamParser interface {
+	Write(fromClient bool, ts time.Time, data []byte)
+	Skip(fromClient bool, ts time.Time, n int)
+}
+
+// chunk is a piece of data read from a channel
+type chunk struct {
+	ts   time.Time
+	data []byte
+}
+
+// capture holds the data sent in one direction of a channel
+// until it has been flushed to the database.
+// Data exceeding the budgets is spilled to disk or discarded.
+type capture struct {
+	pending     []chunk
+	budgets     []*Budget
+	spill       *os.File
+	spillDir    string
+	spillPrefix string
+	truncatedAt int64 // -1 if not truncated
+	total       int64
+	fromClient  bool
+	stderr      bool
+	parser      streamParser
+	tap         *dataTap
+	sync.Mutex
+}
+
+func newCapture(fromClient, stderr bool, spillDir, spillPrefix string, budgets ...*Budget) *capture {
+	return &capture{
+		budgets:     budgets,
+		spillDir:    spillDir,
+		spillPrefix: spillPrefix,
+		truncatedAt: -1,
+		fromClient:  fromClient,
+		stderr:      stderr,
+	}
+}
+
+// Write implements the io.Writer interface
+func (c *capture) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	ts := time.Now()
+	n := c.takeBudget(int64(len(p)))
+	if n > 0 {
+		c.pending = append(c.pending, chunk{ts: ts, data: append([]byte(nil), p[:n]...)})
+		if c.parser != nil {
+			c.parser.Write(c.fromClient, ts, p[:n])
+		}
+		if c.tap != nil {
+			c.tap.emit(ts, p[:n])
+		}
+		c.total += n
+	}
+
+	if n < int64(len(p)) {
+		if c.parser != nil {
+			c.parser.Skip(c.fromClient, ts, len(p)-int(n))
+		}
+		if c.truncatedAt < 0 {
+			c.truncatedAt = c.total
+		}
+		if err := c.spillData(p[n:]); err != nil {
+			// Not worth failing the channel over,
+			// the data is considered truncated
+			c.closeSpill()
+			c.spillDir = ""
+		}
+	}
+
+	return len(p), nil
+}
+
+// record captures data regardless of the budgets and without parsing it,
+// for data that was not read from the channel such as injected data
+func (c *capture) record(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	ts := time.Now()
+	c.pending = append(c.pending, chunk{ts: ts, data: append([]byte(nil), p...)})
+	if c.tap != nil {
+		c.tap.emit(ts, p)
+	}
+	c.total += int64(len(p))
+}
+
+// takeBudget takes up to n bytes from all budgets
+func (c *capture) takeBudget(n int64) int64 {
+	for i, b := range c.budgets {
+		taken := b.take(n)
+		for _, prev := range c.budgets[:i] {
+			prev.giveBack(n - taken)
+		}
+		n = taken
+	}
+	return n
+}
+
+func (c *capture) spillData(p []byte) error {
+	if c.spillDir == "" {
+		return nil
+	}
+
+	var err error
+	if c.spill == nil {
+		if c.spill, err = os.CreateTemp(c.spillDir, c.spillPrefix+"-*"); err != nil {
+			return err
+		}
+	}
+	_, err = c.spill.Write(p)
+	return err
+}
+
+// setParser sets the parser that is fed all data captured from now on
+func (c *capture) setParser(parser streamParser) {
+	c.Lock()
+	c.parser = parser
+	c.Unlock()
+}
+
+// setTap sets the tap that emits all data captured from now on
+func (c *capture) setTap(tap *dataTap) {
+	c.Lock()
+	c.tap = tap
+	c.Unlock()
+}
+
+// chunks returns the chunks that have not yet been flushed
+func (c *capture) chunks() []chunk {
+	c.Lock()
+	defer c.Unlock()
+	return c.pending
+}
+
+// markFlushed forgets the first n pending chunks
+func (c *capture) markFlushed(n int) {
+	c.Lock()
+	c.pending = append([]chunk(nil), c.pending[n:]...)
+	c.Unlock()
+}
+
+// truncation returns the amount of bytes captured before the data got truncated
+// and the file the rest of the data was spilled to, if any
+func (c *capture) truncation() (*int64, *string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.truncatedAt < 0 {
+		return nil, nil
+	}
+	truncatedAt := c.truncatedAt
+	if c.spill == nil {
+		return &truncatedAt, nil
+	}
+	spill := c.spill.Name()
+	return &truncatedAt, &spill
+}
+
+// close closes the spill file, if any
+func (c *capture) close() {
+	c.Lock()
+	c.closeSpill()
+	c.Unlock()
+}
+
+func (c *capture) closeSpill() {
+	if c.spill != nil {
+		// nolint:errcheck // nothing to do about it
+		c.spill.Close()
+	}
+}
+
+// dataTap emits the data captured from one direction of a channel as events.
+// Data exceeding the capture budgets is not emitted either.
+type dataTap struct {
+	events     event.Emitter
+	chID       uint32
+	fromClient bool
+	stderr     bool
+}
+
+func (t *dataTap) emit(ts time.Time, p []byte) {
+	t.events.EmitAt(ts, event.Data, t.chID, map[string]any{
+		"from_client": t.fromClient,
+		"stderr":      t.stderr,
+		"data":        append([]byte(nil), p...), // p is reused by the reader
+	})
+}
@@ -1,86 +1,312 @@
 package channel
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/artifact"
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
+	"github.com/alx99/botpot/internal/botpot/scp"
 	"github.com/alx99/botpot/internal/botpot/sftp"
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/ssh"
 )
 
+// flushInterval is how often captured channel data and requests are flushed to the database
+const flushInterval = time.Second
+
+// sinkholeTimeout is how long to wait for a sinkhole to accept a connection
+const sinkholeTimeout = 10 * time.Second
+
+var errForwardDenied = errors.New("forwarding denied by policy")
+
+// ErrNotOpen is returned when acting on a channel that is not open
+var ErrNotOpen = errors.New("channel is not open")
+
 // Channel represents an SSH channel
 type Channel struct {
 	start        time.Time
 	end          time.Time
 	proxyClosed  atomic.Bool
 	clientClosed atomic.Bool
+	sftp         atomic.Pointer[sftp.Parser]
+	scp          atomic.Pointer[scp.Parser]
 	reqChan      ssh.NewChannel
-	recvStderr   *bytes.Buffer
-	p            *ssh.Client
-	recv         *bytes.Buffer
+	tcpip        *tcpip
+	forward      ForwardRule
+	forwardIP    net.IP // Address the destination was resolved to when deciding
+	policy       ForwardPolicy
+	recvStderr   *capture
+	clientChan   ssh.Channel // Set once the channel is open
+	proxyChan    ssh.Channel
+	peer         ssh.Conn
+	db           db.Store
+	store        artifact.Store
+	events       event.Emitter
+	recv         *capture
+	sent         *capture
+	sentStderr   *capture
+	watchers     watchers
+	reqs         []request // Requests that have not yet been flushed
+	reqMu        sync.Mutex
+	done         chan struct{}
 	channelType  string
 	l            zerolog.Logger
-	reqs         []request
+	wg           sync.WaitGroup
+	openMu       sync.Mutex
+	sessionID    int
 	id           uint32
+	fromClient   bool
 }
 
-// NewChannel creates a new channel
-func NewChannel(id uint32, req ssh.NewChannel, proxy *ssh.Client, l zerolog.Logger) *Channel {
+// NewChannel creates a new channel. The channel is opened on peer once it has
+// been accepted. fromClient is false if the channel was opened by the proxy.
+func NewChannel(id uint32, sessionID int, req ssh.NewChannel, fromClient bool, peer ssh.Conn, database db.Store, store artifact.Store, events event.Emitter, limits Limits, policy ForwardPolicy, sessionBudget *Budget, l zerolog.Logger) *Channel {
+	budget := NewBudget(limits.Channel)
+	prefix := fmt.Sprintf("botpot-%d-%d", sessionID, id)
 	ch := &Channel{
 		start:        time.Now(),
 		end:          time.Time{},
 		reqChan:      req,
-		p:            proxy,
+		peer:         peer,
+		db:           database,
+		store:        store,
+		events:       events,
 		proxyClosed:  atomic.Bool{},
-		recvStderr:   &bytes.Buffer{},
-		recv:         &bytes.Buffer{},
+		recvStderr:   newCapture(true, true, limits.SpillDir, prefix+"-recv-stderr", budget, sessionBudget),
+		recv:         newCapture(true, false, limits.SpillDir, prefix+"-recv", budget, sessionBudget),
+		sent:         newCapture(false, false, limits.SpillDir, prefix+"-sent", budget, sessionBudget),
+		sentStderr:   newCapture(false, true, limits.SpillDir, prefix+"-sent-stderr", budget, sessionBudget),
 		clientClosed: atomic.Bool{},
+		done:         make(chan struct{}),
 		channelType:  req.ChannelType(),
 		l:            l.With().Uint32("chID", id).Logger(),
-		reqs:         []request{},
+		wg:           sync.WaitGroup{},
+		sessionID:    sessionID,
 		id:           id,
+		fromClient:   fromClient,
+		forward:      ForwardRule{Action: ForwardAllow},
+		policy:       policy,
+	}
+
+	var err error
+	if ch.tcpip, err = parseTCPIP(ch.channelType, req.ExtraData()); err != nil {
+		ch.l.Err(err).Str("type", ch.channelType).Msg("Could not parse channel extra data")
 	}
 
 	return ch
 }
 
-// Handle starts handleling the channel and
-// accepts new channel requests
+// Handle starts handling the channel in the background. Deciding where
+// to forward it and opening it on the peer may take a while, which must
+// not hold up the other channels of the session.
 func (c *Channel) Handle() {
-	c.l.Info().Str("type", c.reqChan.ChannelType()).Str("extraData", string(c.reqChan.ExtraData())).Msg("Wants to open channel")
+	go c.handle()
+}
 
-	proxyChan, proxyReqChan, err := c.p.OpenChannel(c.reqChan.ChannelType(), c.reqChan.ExtraData())
-	if err != nil {
-		c.end = time.Now() // ensure endtime is not null value in case of error
+func (c *Channel) handle() {
+	if c.tcpip != nil && c.channelType == DirectTCPIPChannel && c.fromClient {
+		c.forward, c.forwardIP = c.policy.Decide(c.tcpip.Address, c.tcpip.Port)
+	}
+
+	l := c.l.Info().Str("type", c.channelType).Bool("fromClient", c.fromClient)
+	if c.tcpip != nil {
+		l = l.Str("address", c.tcpip.Address).Uint32("port", c.tcpip.Port).
+			Str("originatorAddress", c.tcpip.OriginatorAddress).Uint32("originatorPort", c.tcpip.OriginatorPort).
+			Str("action", c.forward.Action)
+	} else {
+		l = l.Str("extraData", string(c.reqChan.ExtraData()))
+	}
+	l.Msg("Wants to open channel")
+
+	if err := c.db.BeginTx(c.insert); err != nil {
+		c.l.Err(err).Msg("Could not insert channel into DB")
+	}
+	c.emitOpen()
+
+	peerChan, peerReqChan, err := c.openPeer()
+	if errors.Is(err, errForwardDenied) {
+		if err = c.reqChan.Reject(ssh.Prohibited, "administratively prohibited"); err != nil {
+			c.l.Err(err).Msg("Could not reject channel request")
+		}
+		go c.close()
+		return
+	} else if err != nil {
 		c.l.Err(err).Msg("Could not open channel")
 		if err = c.reqChan.Reject(ssh.ConnectionFailed, ""); err != nil {
 			c.l.Err(err).Msg("Could not reject channel request")
 		}
+		go c.close()
 		return
 	}
 
-	clientChan, clientReqChan, err := c.reqChan.Accept()
+	acceptedChan, acceptedReqChan, err := c.reqChan.Accept()
 	if err != nil {
-		c.end = time.Now() // ensure endtime is not null value in case of error
 		c.l.Err(err).Msg("Could not accept channel request")
+		// nolint:errcheck // nothing to do about it
+		peerChan.Close()
+		go c.close()
 		return
 	}
 
+	clientChan, clientReqChan, proxyChan, proxyReqChan := acceptedChan, acceptedReqChan, peerChan, peerReqChan
+	if !c.fromClient {
+		clientChan, clientReqChan, proxyChan, proxyReqChan = peerChan, peerReqChan, acceptedChan, acceptedReqChan
+	}
+
+	c.openMu.Lock()
+	c.clientChan, c.proxyChan = clientChan, proxyChan
+	c.openMu.Unlock()
+
+	c.wg.Add(6)
 	c.proxyChannelData(clientChan, proxyChan)           // handle the new channel
 	go c.handleRequest(proxyChan, clientReqChan, true)  // client to proxy
 	go c.handleRequest(clientChan, proxyReqChan, false) // proxy to client
+	go c.flushLoop()
+	go func() {
+		c.wg.Wait()
+		c.close()
+	}()
+}
+
+// openPeer opens the channel on the peer, unless
+// the forwarding policy says to go elsewhere
+func (c *Channel) openPeer() (ssh.Channel, <-chan *ssh.Request, error) {
+	switch c.forward.Action {
+	case ForwardDeny:
+		return nil, nil, errForwardDenied
+	case ForwardSinkhole:
+		if c.forward.service != nil {
+			local, remote := net.Pipe()
+			go c.forward.service.ServeConn(remote, c.sessionID, c.id)
+			ch, reqs := newConnChannel(local)
+			return ch, reqs, nil
+		}
+		conn, err := net.DialTimeout("tcp", c.forward.Sinkhole, sinkholeTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		ch, reqs := newConnChannel(conn)
+		return ch, reqs, nil
+	case ForwardEmulate:
+		ch, reqs := newEmulatedChannel(c.tcpip.Port)
+		return ch, reqs, nil
+	}
+	extraData := c.reqChan.ExtraData()
+	if c.forwardIP != nil {
+		// The peer resolving the destination again could yield an
+		// address that was not checked, so it gets the checked one
+		dst := *c.tcpip
+		dst.Address = c.forwardIP.String()
+		extraData = ssh.Marshal(&dst)
+	}
+	return c.peer.OpenChannel(c.channelType, extraData)
+}
+
+// emitOpen emits the event of the channel being opened
+func (c *Channel) emitOpen() {
+	data := map[string]any{
+		"type":        c.channelType,
+		"from_client": c.fromClient,
+	}
+	if c.tcpip != nil {
+		data["address"] = c.tcpip.Address
+		data["port"] = c.tcpip.Port
+		data["originator_address"] = c.tcpip.OriginatorAddress
+		data["originator_port"] = c.tcpip.OriginatorPort
+		data["action"] = c.forward.Action
+	}
+	c.events.EmitAt(c.start, event.ChannelOpen, c.id, data)
+}
+
+// tap makes capt emit the data it captures as events and returns it
+func (c *Channel) tap(capt *capture) io.Writer {
+	capt.setTap(&dataTap{events: c.events, chID: c.id, fromClient: capt.fromClient, stderr: capt.stderr})
+	return capt
+}
+
+// ID returns the ID of the channel within its session
+func (c *Channel) ID() uint32 {
+	return c.id
+}
+
+// Type returns the type of the channel
+func (c *Channel) Type() string {
+	return c.channelType
+}
+
+// Started returns when the channel was opened
+func (c *Channel) Started() time.Time {
+	return c.start
+}
+
+// Inject sends data to the client as if it came from the proxy. The data
+// is captured like the data of the proxy so that transcripts and replays
+// show what the client saw.
+func (c *Channel) Inject(data []byte) error {
+	clientChan, _ := c.opened()
+	if clientChan == nil {
+		return ErrNotOpen
+	}
+	n, err := clientChan.Write(data)
+	c.sent.record(data[:n])
+	c.watchers.send(false, data[:n])
+	return err
+}
+
+// Watch returns a watcher receiving what the client sees on the channel
+// from now on, and what the client sends if input is true
+func (c *Channel) Watch(input bool) *Watcher {
+	return c.watchers.add(input)
+}
+
+// Unwatch stops passing data to w
+func (c *Channel) Unwatch(w *Watcher) {
+	c.watchers.remove(w)
+}
+
+// Kill closes the channel on both the client and the proxy
+func (c *Channel) Kill() error {
+	clientChan, proxyChan := c.opened()
+	if clientChan == nil {
+		return ErrNotOpen
+	}
+	return errors.Join(proxyChan.Close(), clientChan.Close())
+}
+
+// opened returns the client and proxy ends of the channel, nil if it never opened
+func (c *Channel) opened() (ssh.Channel, ssh.Channel) {
+	c.openMu.Lock()
+	defer c.openMu.Unlock()
+	return c.clientChan, c.proxyChan
+}
+
+// Done returns a channel which is closed once the
+// channel has closed and all of its data has been written
+func (c *Channel) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the channel has closed and
+// all of its data has been written to the database
+func (c *Channel) Wait() {
+	<-c.done
 }
 
 // proxyChannelData proxies data between two SSH channels
 func (c *Channel) proxyChannelData(clientChan, proxyChan ssh.Channel) {
 	clientClosed := atomic.Bool{}
 	proxyFunc := func(read io.Reader, write io.Writer, fromClient bool) {
+		defer c.wg.Done()
 		n, err := io.Copy(write, read)
 		defer func() { c.l.Debug().Bool("fromClient", fromClient).Int64("bytesRead", n).Send() }()
 		if err != nil {
@@ -105,35 +331,61 @@ func (c *Channel) proxyChannelData(clientChan, proxyChan ssh.Channel) {
 		}
 	}
 
-	go proxyFunc(io.TeeReader(clientChan, c.recv), proxyChan, true)
-	go proxyFunc(io.TeeReader(clientChan.Stderr(), c.recvStderr), proxyChan.Stderr(), true)
-	go proxyFunc(proxyChan, clientChan, false)
-	go proxyFunc(proxyChan.Stderr(), clientChan.Stderr(), false)
+	// Watchers are fed directly so that they keep
+	// receiving data once the capture budgets are spent
+	clientTee := watchTee{watchers: &c.watchers, fromClient: true}
+	proxyTee := watchTee{watchers: &c.watchers, fromClient: false}
+	go proxyFunc(io.TeeReader(clientChan, io.MultiWriter(c.tap(c.recv), clientTee)), proxyChan, true)
+	go proxyFunc(io.TeeReader(clientChan.Stderr(), io.MultiWriter(c.tap(c.recvStderr), clientTee)), proxyChan.Stderr(), true)
+	go proxyFunc(io.TeeReader(proxyChan, io.MultiWriter(c.tap(c.sent), proxyTee)), clientChan, false)
+	go proxyFunc(io.TeeReader(proxyChan.Stderr(), io.MultiWriter(c.tap(c.sentStderr), proxyTee)), clientChan.Stderr(), false)
 }
 
 // handleRequest proxies requests between an SSH server and an SSH client
 func (c *Channel) handleRequest(channel ssh.Channel, reqChan <-chan *ssh.Request, fromClient bool) {
+	defer c.wg.Done()
 	for req := range reqChan {
-		parsedReq, err := newRequest(req, fromClient, c.id, c.l.With().Bool("fromClient", fromClient).Logger())
-		if err != nil {
-			c.l.Err(err).Bool("fromClient", fromClient).Msg("Error while creating a new Request")
-		} else {
-			c.reqs = append(c.reqs, parsedReq)
+		common := newCommonReq(req, fromClient, c.id)
+		parsedReq := newRequest(req, common, c.l.With().Bool("fromClient", fromClient).Logger())
+
+		// Look for SFTP subsystem
+		if v, ok := parsedReq.(*subSystemRequest); ok && strings.ToLower(v.Name) == "sftp" {
+			// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-3.1
+			parser := sftp.NewParser(c.l)
+			if c.sftp.CompareAndSwap(nil, parser) {
+				c.recv.setParser(parser)
+				c.sent.setParser(parser)
+			}
+		}
+
+		// Look for scp in sink or source mode
+		if v, ok := parsedReq.(*execReq); ok && fromClient {
+			if cmd, ok := scp.ParseCommand(v.command); ok {
+				parser := scp.NewParser(cmd, c.l)
+				if c.scp.CompareAndSwap(nil, parser) {
+					c.recv.setParser(parser)
+					c.sent.setParser(parser)
+				}
+			}
 		}
 
+		t := time.Now()
 		res, err := channel.SendRequest(req.Type, req.WantReply, req.Payload)
+		common.setReply(res, err, time.Since(t))
 		if err != nil {
 			c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to proxy request")
-			if req.WantReply {
-				if err = req.Reply(false, nil); err != nil {
-					c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to reply to request")
-				}
-			}
-		} else if req.WantReply {
+			res = false
+		}
+		if req.WantReply {
 			if err = req.Reply(res, nil); err != nil {
 				c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to reply to request")
 			}
 		}
+
+		c.events.EmitAt(common.ts, event.Request, c.id, parsedReq.eventData())
+		c.reqMu.Lock()
+		c.reqs = append(c.reqs, parsedReq)
+		c.reqMu.Unlock()
 	}
 
 	// Here we know there will be no new requests from the proxy
@@ -162,39 +414,229 @@ func (c *Channel) handleRequest(channel ssh.Channel, reqChan <-chan *ssh.Request
 	c.l.Info().Bool("fromClient", fromClient).Msg("All requests served")
 }
 
-// Insert tries to insert the data into the database
-func (c *Channel) Insert(tx pgx.Tx, sessionID int) error {
-	_, err := tx.Exec(context.TODO(), `
-	INSERT INTO Channel(id, session_id, channel_type, recv, recv_stderr, start_ts, end_ts)
-		VALUES($1, $2, $3, $4, $5, $6, $7)
-`, c.id, sessionID, c.channelType, c.recv.Bytes(), c.recvStderr.Bytes(), c.start, c.end)
-	if err != nil {
+// flushLoop periodically flushes the captured data and
+// the requests to the database until the channel is closed
+func (c *Channel) flushLoop() {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// captures returns the captures of all directions of the channel
+func (c *Channel) captures() []*capture {
+	return []*capture{c.recv, c.recvStderr, c.sent, c.sentStderr}
+}
+
+// flush inserts the captured chunks and the requests
+// that have not yet been flushed into the database
+func (c *Channel) flush() {
+	chunks := make([][]chunk, 0, 4)
+	rows := [][]any{}
+	for _, capt := range c.captures() {
+		pending := capt.chunks()
+		chunks = append(chunks, pending)
+		for _, chunk := range pending {
+			rows = append(rows, []any{c.sessionID, c.id, chunk.ts, capt.fromClient, capt.stderr, chunk.data})
+		}
+	}
+	c.reqMu.Lock()
+	reqs := c.reqs
+	c.reqMu.Unlock()
+	if len(rows) == 0 && len(reqs) == 0 {
+		return
+	}
+
+	err := c.db.BeginTx(func(tx pgx.Tx) error {
+		for _, r := range reqs {
+			if err := r.Insert(tx, c.sessionID); err != nil {
+				return err
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		_, err := tx.CopyFrom(context.TODO(),
+			pgx.Identifier{"channeldata"},
+			[]string{"session_id", "channel_id", "ts", "from_client", "stderr", "data"},
+			pgx.CopyFromRows(rows))
 		return err
+	})
+	if err != nil {
+		c.l.Err(err).Msg("Could not flush channel data to DB")
+		return
+	}
+
+	for i, capt := range c.captures() {
+		capt.markFlushed(len(chunks[i]))
+	}
+	c.reqMu.Lock()
+	c.reqs = append([]request(nil), c.reqs[len(reqs):]...)
+	c.reqMu.Unlock()
+}
+
+// close flushes the remaining data and marks the channel as closed
+func (c *Channel) close() {
+	defer close(c.done)
+	c.watchers.close()
+
+	if c.end.IsZero() {
+		c.end = time.Now()
+	}
+
+	c.flush()
+	for _, capt := range c.captures() {
+		capt.close()
+	}
+	c.events.EmitAt(c.end, event.ChannelClose, c.id, nil)
+
+	// Files that could not be stored get no blob, which would point nowhere
+	unstored := map[string]bool{}
+	sftpParser := c.sftp.Load()
+	if sftpParser != nil {
+		sftpParser.Close(c.end)
+		for _, f := range sftpParser.Files {
+			if !c.storeFile(f.Path, f.Data) {
+				unstored[f.SHA256] = true
+			}
+		}
+	}
+	scpParser := c.scp.Load()
+	if scpParser != nil {
+		scpParser.Close(c.end)
+		for _, f := range scpParser.Files {
+			if !c.storeFile(f.Path, f.Data) {
+				unstored[f.SHA256] = true
+			}
+		}
 	}
 
-	sftpFound := false
-	for _, req := range c.reqs {
-		if err = req.Insert(tx, sessionID); err != nil {
+	err := c.db.BeginTx(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.TODO(), `
+	UPDATE Channel SET end_ts = $1 WHERE id = $2 AND session_id = $3
+`, c.end, c.id, c.sessionID)
+		if err != nil {
 			return err
 		}
 
-		// Look for SFTP subsystem
-		switch v := req.(type) {
-		case *subSystemRequest:
-			if strings.ToLower(v.Name) == "sftp" {
-				sftpFound = true
+		for _, capt := range c.captures() {
+			truncatedAt, spill := capt.truncation()
+			if truncatedAt == nil {
+				continue
 			}
-		default:
+
+			c.l.Warn().Bool("fromClient", capt.fromClient).Bool("stderr", capt.stderr).
+				Int64("truncatedAt", *truncatedAt).Msg("Channel data exceeded the capture limits")
+			_, err = tx.Exec(context.TODO(), `
+	INSERT INTO ChannelTruncation(session_id, channel_id, from_client, stderr, truncated_at, spill)
+		VALUES($1, $2, $3, $4, $5, $6)
+`, c.sessionID, c.id, capt.fromClient, capt.stderr, *truncatedAt, spill)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.l.Err(err).Msg("Could not update channel in DB")
+	}
+
+	// What the parsers found is inserted separately so that
+	// a row rejected by the DB can not lose the channel
+	if sftpParser != nil {
+		if err = c.db.BeginTx(func(tx pgx.Tx) error { return c.insertSFTP(tx, sftpParser, unstored) }); err != nil {
+			c.l.Err(err).Msg("Could not insert SFTP transfers into DB")
+		}
+	}
+	if scpParser != nil {
+		if err = c.db.BeginTx(func(tx pgx.Tx) error { return c.insertSCP(tx, scpParser, unstored) }); err != nil {
+			c.l.Err(err).Msg("Could not insert SCP transfers into DB")
 		}
 	}
+}
+
+// storeFile stores the content of a transferred file in the artifact store
+// and reports whether it was stored
+func (c *Channel) storeFile(path string, data []byte) bool {
+	if _, err := c.store.Put(data); err != nil {
+		c.l.Err(err).Str("path", path).Msg("Could not store transferred file, not recording its blob")
+		return false
+	}
+	return true
+}
 
-	if sftpFound {
-		// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-13#section-3.1
-		parser := sftp.NewParser(c.recv.Bytes(), c.l)
-		if err = parser.Parse(); err != nil {
+// insertSFTP inserts what the SFTP parser found, if any.
+// Files whose hash is in unstored get no blob sighting.
+func (c *Channel) insertSFTP(tx pgx.Tx, parser *sftp.Parser, unstored map[string]bool) error {
+	if parser == nil {
+		return nil
+	}
+	for _, op := range parser.Operations {
+		if err := op.Insert(tx, c.sessionID, c.id); err != nil {
+			return err
+		}
+	}
+	for _, f := range parser.Files {
+		if err := f.Insert(tx, c.sessionID, c.id); err != nil {
+			return err
+		}
+		if unstored[f.SHA256] {
+			continue
+		}
+		s := artifact.Sighting{TS: c.end, SHA256: f.SHA256, Source: artifact.SourceSFTP, Path: f.Path, Size: int64(len(f.Data))}
+		if err := s.Insert(tx, c.sessionID); err != nil {
+			return err
+		}
+	}
+	for _, a := range parser.Anomalies {
+		if err := a.Insert(tx, c.sessionID, c.id); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+// insertSCP inserts what the SCP parser found, if any.
+// Files whose hash is in unstored get no blob sighting.
+func (c *Channel) insertSCP(tx pgx.Tx, parser *scp.Parser, unstored map[string]bool) error {
+	if parser == nil {
+		return nil
+	}
+	for _, f := range parser.Files {
+		if err := f.Insert(tx, c.sessionID, c.id); err != nil {
+			return err
+		}
+		if unstored[f.SHA256] {
+			continue
+		}
+		s := artifact.Sighting{TS: c.end, SHA256: f.SHA256, Source: artifact.SourceSCP, Path: f.Path, Size: int64(len(f.Data))}
+		if err := s.Insert(tx, c.sessionID); err != nil {
+			return err
+		}
+	}
+	for _, a := range parser.Anomalies {
+		if err := a.Insert(tx, c.sessionID, c.id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// insert tries to insert the channel into the database
+func (c *Channel) insert(tx pgx.Tx) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO Channel(id, session_id, channel_type, from_client, start_ts)
+		VALUES($1, $2, $3, $4, $5)
+`, c.id, c.sessionID, db.Sanitize(c.channelType), c.fromClient, c.start)
+	if err != nil || c.tcpip == nil {
+		return err
+	}
+	return c.tcpip.Insert(tx, c.sessionID, c.id, c.forward.Action)
+}
@@ -0,0 +1,109 @@
+package channel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// banners are sent by emulated services, keyed by port
+var banners = map[uint32]string{
+	21:  "220 (vsFTPd 3.0.5)\r\n",
+	22:  "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1\r\n",
+	25:  "220 mail.localdomain ESMTP Postfix (Ubuntu)\r\n",
+	110: "+OK Dovecot (Ubuntu) ready.\r\n",
+	143: "* OK [CAPABILITY IMAP4rev1 SASL-IR LOGIN-REFERRALS ID ENABLE IDLE LITERAL+ STARTTLS AUTH=PLAIN] Dovecot (Ubuntu) ready.\r\n",
+	587: "220 mail.localdomain ESMTP Postfix (Ubuntu)\r\n",
+}
+
+// connChannel makes a net.Conn usable as an ssh.Channel
+// that has no stderr and does not support requests
+type connChannel struct {
+	net.Conn
+	eof    chan struct{}
+	once   sync.Once
+	closed atomic.Bool
+}
+
+// newConnChannel creates a channel on top of the connection and
+// a request channel that is already closed since none will arrive
+func newConnChannel(conn net.Conn) (ssh.Channel, <-chan *ssh.Request) {
+	reqs := make(chan *ssh.Request)
+	close(reqs)
+	return &connChannel{Conn: conn, eof: make(chan struct{})}, reqs
+}
+
+// newEmulatedChannel creates a channel connected to a fake service
+// which sends the banner for the port and discards whatever it receives
+func newEmulatedChannel(port uint32) (ssh.Channel, <-chan *ssh.Request) {
+	local, remote := net.Pipe()
+	go func() {
+		// nolint:errcheck // the client might already be gone
+		defer remote.Close()
+		if banner, ok := banners[port]; ok {
+			if _, err := io.WriteString(remote, banner); err != nil {
+				return
+			}
+		}
+		// nolint:errcheck // only stops once the client is gone
+		io.Copy(io.Discard, remote)
+	}()
+	return newConnChannel(local)
+}
+
+// Read implements the ssh.Channel interface
+func (c *connChannel) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.once.Do(func() { close(c.eof) })
+		if c.closed.Load() || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+			// Closing a channel ends it like any other EOF
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// Close implements the ssh.Channel interface
+func (c *connChannel) Close() error {
+	c.closed.Store(true)
+	c.once.Do(func() { close(c.eof) })
+	return c.Conn.Close()
+}
+
+// CloseWrite implements the ssh.Channel interface
+func (c *connChannel) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// SendRequest implements the ssh.Channel interface
+func (c *connChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return false, nil
+}
+
+// Stderr implements the ssh.Channel interface
+func (c *connChannel) Stderr() io.ReadWriter {
+	return connStderr{eof: c.eof}
+}
+
+// connStderr discards everything written to it and
+// reaches EOF together with the channel it belongs to
+type connStderr struct {
+	eof chan struct{}
+}
+
+func (s connStderr) Read([]byte) (int, error) {
+	<-s.eof
+	return 0, io.EOF
+}
+
+func (s connStderr) Write(p []byte) (int, error) {
+	return len(p), nil
+}
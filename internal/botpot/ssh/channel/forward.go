@@ -0,0 +1,244 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolveTimeout is how long resolving the destination of a forwarding channel may take
+const resolveTimeout = 5 * time.Second
+
+// Actions taken for port forwarding channels
+const (
+	ForwardAllow    = "allow"    // Forward to the destination through the honeypot
+	ForwardDeny     = "deny"     // Reject the channel
+	ForwardSinkhole = "sinkhole" // Connect to a local sinkhole instead of the destination
+	ForwardEmulate  = "emulate"  // Serve a canned banner without connecting anywhere
+)
+
+// Sinkhole is a local service that forwarded connections can be terminated into
+type Sinkhole interface {
+	// ServeConn serves the connection of a channel until it is closed
+	ServeConn(conn net.Conn, sessionID int, channelID uint32)
+}
+
+// ForwardRule decides what to do with forwarding channels
+// whose destination matches the host and port
+type ForwardRule struct {
+	host     string // "*" matches every host
+	cidr     *net.IPNet
+	Action   string
+	Sinkhole string // Name of a built-in sinkhole or address to connect to when sinkholing
+	service  Sinkhole
+	portLow  uint32
+	portHigh uint32
+}
+
+// ForwardPolicy decides what to do with direct-tcpip channels opened by clients.
+// The first matching rule wins, destinations not matched by any rule are allowed
+// unless they can not be resolved.
+type ForwardPolicy []ForwardRule
+
+// ParseForwardPolicy parses rules of the form host:port=action where
+//   - host is *, a CIDR or a hostname, IPv6 addresses need brackets
+//   - port is *, a port or a range of ports such as 1-1024
+//   - action is allow, deny, emulate or sinkhole:name where name
+//     is one of the built-in sinkholes or an address to connect to
+//
+// Destinations are resolved before matching, so CIDRs also match hostnames
+// and the shorthand IPv4 notations such as 127.1 that resolve into them.
+func ParseForwardPolicy(rules []string, sinkholes map[string]Sinkhole) (ForwardPolicy, error) {
+	policy := ForwardPolicy{}
+	for _, r := range rules {
+		rule, err := parseForwardRule(strings.TrimSpace(r), sinkholes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forwarding rule %q: %w", r, err)
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+func parseForwardRule(r string, sinkholes map[string]Sinkhole) (ForwardRule, error) {
+	rule := ForwardRule{}
+
+	dst, action, ok := strings.Cut(r, "=")
+	if !ok {
+		return rule, fmt.Errorf("missing action")
+	}
+
+	action, rule.Sinkhole, _ = strings.Cut(action, ":")
+	rule.Action = action
+	switch action {
+	case ForwardAllow, ForwardDeny, ForwardEmulate:
+	case ForwardSinkhole:
+		if s, ok := sinkholes[rule.Sinkhole]; ok {
+			rule.service = s
+		} else if _, _, err := net.SplitHostPort(rule.Sinkhole); err != nil {
+			return rule, fmt.Errorf("invalid sinkhole address: %w", err)
+		}
+	default:
+		return rule, fmt.Errorf("unknown action %q", action)
+	}
+
+	host, port, err := net.SplitHostPort(dst)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.host = strings.ToLower(host)
+	if strings.Contains(host, "/") {
+		if _, rule.cidr, err = net.ParseCIDR(host); err != nil {
+			return rule, err
+		}
+	}
+
+	if port == "*" {
+		rule.portLow, rule.portHigh = 0, 65535
+		return rule, nil
+	}
+	low, high, isRange := strings.Cut(port, "-")
+	if !isRange {
+		high = low
+	}
+	l, err := strconv.ParseUint(low, 10, 16)
+	if err != nil {
+		return rule, err
+	}
+	h, err := strconv.ParseUint(high, 10, 16)
+	if err != nil {
+		return rule, err
+	}
+	if l > h {
+		return rule, fmt.Errorf("inverted port range %s", port)
+	}
+	rule.portLow, rule.portHigh = uint32(l), uint32(h)
+	return rule, nil
+}
+
+// Match reports whether the rule applies to the destination host, which
+// resolved to ip. ip is nil if the host could not be resolved.
+func (r ForwardRule) Match(host string, ip net.IP, port uint32) bool {
+	if port < r.portLow || port > r.portHigh {
+		return false
+	}
+	if r.host == "*" {
+		return true
+	}
+	if r.cidr != nil {
+		return ip != nil && r.cidr.Contains(ip)
+	}
+	if ruleIP := parseIP(r.host); ruleIP != nil {
+		return ip != nil && ip.Equal(ruleIP)
+	}
+	return strings.EqualFold(strings.TrimSuffix(host, "."), strings.TrimSuffix(r.host, "."))
+}
+
+// Decide returns the rule to apply to the destination and the address it
+// resolved to, which allowed channels have to be forwarded to. Every address
+// the destination resolves to has to be allowed, otherwise the first rule not
+// allowing one of them is returned. Destinations that can not be resolved
+// are denied unless a rule matches them by name, the address is nil then.
+func (p ForwardPolicy) Decide(host string, port uint32) (ForwardRule, net.IP) {
+	ips := resolve(host)
+	if len(ips) == 0 {
+		if r, ok := p.first(host, nil, port); ok {
+			return r, nil
+		}
+		return ForwardRule{Action: ForwardDeny}, nil
+	}
+
+	decision := ForwardRule{Action: ForwardAllow}
+	for i, ip := range ips {
+		r, ok := p.first(host, ip, port)
+		if !ok {
+			continue
+		}
+		if r.Action != ForwardAllow {
+			return r, nil
+		}
+		if i == 0 {
+			decision = r
+		}
+	}
+	return decision, ips[0]
+}
+
+// first returns the first rule matching the destination
+func (p ForwardPolicy) first(host string, ip net.IP, port uint32) (ForwardRule, bool) {
+	for _, r := range p {
+		if r.Match(host, ip, port) {
+			return r, true
+		}
+	}
+	return ForwardRule{}, false
+}
+
+// resolve returns the addresses of host, which may be a hostname or an IP address
+func resolve(host string) []net.IP {
+	if ip := parseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips
+}
+
+// parseIP parses an IP address, including the IPv4 notations accepted
+// by inet_aton such as 127.1 or 2130706433, and IPv6 addresses with zones
+func parseIP(s string) net.IP {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	nums := make([]uint64, len(parts))
+	for i, part := range parts {
+		base := 10
+		switch {
+		case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+			part, base = part[2:], 16
+		case len(part) > 1 && part[0] == '0':
+			part, base = part[1:], 8
+		}
+		n, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return nil
+		}
+		nums[i] = n
+	}
+
+	// All but the last part are single bytes, the last one fills the remaining bytes
+	last := len(nums) - 1
+	v := nums[last]
+	if v >= 1<<(8*(4-last)) {
+		return nil
+	}
+	for i := 0; i < last; i++ {
+		if nums[i] > 255 {
+			return nil
+		}
+		v |= nums[i] << (8 * (3 - i))
+	}
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
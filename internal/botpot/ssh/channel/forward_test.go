@@ -0,0 +1,179 @@
+package channel
+
+import (
+	"net"
+	"testing"
+)
+
+type nopSinkhole struct{}
+
+func (nopSinkhole) ServeConn(conn net.Conn, sessionID int, channelID uint32) {}
+
+var testSinkholes = map[string]Sinkhole{"smtp": nopSinkhole{}}
+
+func TestParseForwardPolicy(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{rule: "*:*=allow"},
+		{rule: "*:25=sinkhole:smtp"},
+		{rule: "10.0.0.0/8:1-1024=deny"},
+		{rule: "[::1]:22=deny"},
+		{rule: "[fe80::/10]:*=deny"},
+		{rule: "example.com:443=emulate"},
+		{rule: " *:80=sinkhole:127.0.0.1:8080 "},
+		{rule: "*:*", wantErr: true},
+		{rule: "*:*=drop", wantErr: true},
+		{rule: "*:25=sinkhole:pop3", wantErr: true},
+		{rule: "*=allow", wantErr: true},
+		{rule: "10.0.0.0/33:*=deny", wantErr: true},
+		{rule: "*:1024-1=deny", wantErr: true},
+		{rule: "*:65536=deny", wantErr: true},
+		{rule: "*:-1=deny", wantErr: true},
+		{rule: "*:http=deny", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := ParseForwardPolicy([]string{tt.rule}, testSinkholes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string // empty if s is not an address
+	}{
+		{s: "127.0.0.1", want: "127.0.0.1"},
+		{s: "127.1", want: "127.0.0.1"},
+		{s: "127.0.1", want: "127.0.0.1"},
+		{s: "2130706433", want: "127.0.0.1"},
+		{s: "0x7f000001", want: "127.0.0.1"},
+		{s: "0x7f.1", want: "127.0.0.1"},
+		{s: "0177.0.0.1", want: "127.0.0.1"},
+		{s: "017700000001", want: "127.0.0.1"},
+		{s: "10.65536", want: "10.1.0.0"},
+		{s: "0", want: "0.0.0.0"},
+		{s: "::1", want: "::1"},
+		{s: "[::1]", want: "::1"},
+		{s: "fe80::1%eth0", want: "fe80::1"},
+		{s: "::ffff:127.0.0.1", want: "127.0.0.1"},
+		{s: "256.0.0.1"},
+		{s: "10.16777216"},
+		{s: "1.2.3.4.5"},
+		{s: "4294967296"},
+		{s: "08.0.0.1"},
+		{s: "0x"},
+		{s: "1..1"},
+		{s: ""},
+		{s: "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got := parseIP(tt.s)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("got %s, want nil", got)
+				}
+				return
+			}
+			if !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule string
+		host string
+		ip   string // empty if the host did not resolve
+		port uint32
+		want bool
+	}{
+		{rule: "*:*=allow", host: "example.com", port: 80, want: true},
+		{rule: "*:22=deny", host: "10.0.0.1", ip: "10.0.0.1", port: 22, want: true},
+		{rule: "*:22=deny", host: "10.0.0.1", ip: "10.0.0.1", port: 23},
+		{rule: "*:20-25=deny", host: "10.0.0.1", ip: "10.0.0.1", port: 20, want: true},
+		{rule: "*:20-25=deny", host: "10.0.0.1", ip: "10.0.0.1", port: 25, want: true},
+		{rule: "*:20-25=deny", host: "10.0.0.1", ip: "10.0.0.1", port: 26},
+		{rule: "127.0.0.0/8:*=deny", host: "127.1", ip: "127.0.0.1", port: 22, want: true},
+		{rule: "127.0.0.0/8:*=deny", host: "localhost", ip: "127.0.0.1", port: 22, want: true},
+		{rule: "127.0.0.0/8:*=deny", host: "unresolvable", port: 22},
+		{rule: "127.0.0.1:*=deny", host: "2130706433", ip: "127.0.0.1", port: 22, want: true},
+		{rule: "127.1:*=deny", host: "127.0.0.1", ip: "127.0.0.1", port: 22, want: true},
+		{rule: "[::1]:*=deny", host: "::1", ip: "::1", port: 22, want: true},
+		{rule: "example.com:*=deny", host: "EXAMPLE.com.", ip: "93.184.216.34", port: 22, want: true},
+		{rule: "example.com:*=deny", host: "www.example.com", ip: "93.184.216.34", port: 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule+" "+tt.host, func(t *testing.T) {
+			policy, err := ParseForwardPolicy([]string{tt.rule}, testSinkholes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy[0].Match(tt.host, net.ParseIP(tt.ip), tt.port); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardPolicyDecide(t *testing.T) {
+	rules := []string{
+		"127.0.0.0/8:*=deny",
+		"[::1]:*=deny",
+		"*:25=sinkhole:smtp",
+		"10.0.0.0/8:80=emulate",
+		"10.0.0.0/8:*=allow",
+		"192.168.0.0/16:*=deny",
+	}
+	policy, err := ParseForwardPolicy(rules, testSinkholes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host   string
+		port   uint32
+		action string
+		ip     string // Address to forward to, empty if nil
+	}{
+		{host: "127.0.0.1", port: 22, action: ForwardDeny},
+		{host: "127.1", port: 22, action: ForwardDeny},
+		{host: "0x7f000001", port: 80, action: ForwardDeny},
+		{host: "[::1]", port: 22, action: ForwardDeny},
+		{host: "::ffff:127.0.0.1", port: 22, action: ForwardDeny},
+		{host: "1.2.3.4", port: 25, action: ForwardSinkhole},
+		{host: "10.1.2.3", port: 80, action: ForwardEmulate},
+		{host: "10.1.2.3", port: 443, action: ForwardAllow, ip: "10.1.2.3"},
+		{host: "10.65536", port: 443, action: ForwardAllow, ip: "10.1.0.0"},
+		{host: "192.168.1.1", port: 443, action: ForwardDeny},
+		{host: "8.8.8.8", port: 53, action: ForwardAllow, ip: "8.8.8.8"},
+		{host: "134744072", port: 53, action: ForwardAllow, ip: "8.8.8.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			rule, ip := policy.Decide(tt.host, tt.port)
+			if rule.Action != tt.action {
+				t.Errorf("got action %s, want %s", rule.Action, tt.action)
+			}
+			if tt.ip == "" {
+				if ip != nil {
+					t.Errorf("got address %s, want nil", ip)
+				}
+			} else if !ip.Equal(net.ParseIP(tt.ip)) {
+				t.Errorf("got address %s, want %s", ip, tt.ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package channel
+
+import "sync/atomic"
+
+// Limits holds the limits of how much channel data is kept
+type Limits struct {
+	// SpillDir is where data exceeding the limits is written.
+	// If empty, the data is discarded instead.
+	SpillDir string
+	// Channel is the maximum amount of bytes captured per channel, 0 means no limit
+	Channel int64
+	// Session is the maximum amount of bytes captured per session, 0 means no limit
+	Session int64
+}
+
+// Budget keeps track of how many bytes may still be captured
+type Budget struct {
+	remaining atomic.Int64
+	unlimited bool
+}
+
+// NewBudget creates a new budget of limit bytes, 0 means no limit
+func NewBudget(limit int64) *Budget {
+	b := &Budget{unlimited: limit <= 0}
+	b.remaining.Store(limit)
+	return b
+}
+
+// take takes up to n bytes from the budget and
+// returns how many bytes that were taken
+func (b *Budget) take(n int64) int64 {
+	if b.unlimited {
+		return n
+	}
+	for {
+		remaining := b.remaining.Load()
+		taken := n
+		if remaining < taken {
+			taken = remaining
+		}
+		if b.remaining.CompareAndSwap(remaining, remaining-taken) {
+			return taken
+		}
+	}
+}
+
+// giveBack returns n unused bytes to the budget
+func (b *Budget) giveBack(n int64) {
+	if !b.unlimited {
+		b.remaining.Add(n)
+	}
+}
@@ -2,9 +2,9 @@ package channel
 
 import (
 	"context"
-	"fmt"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/db"
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/ssh"
@@ -25,30 +25,80 @@ const (
 	SignalRequest                = "signal"        // RFC 4254 6.9
 	ExitStatusRequest            = "exit-status"   // RFC 4254 6.10
 	ExitSignalRequest            = "exit-signal"   // RFC 4254 6.10
+	BreakRequest                 = "break"         // RFC 4335 3
 )
 
 type request interface {
 	Insert(tx pgx.Tx, sessionID int) error
+	eventData() map[string]any
 }
 
 type commonReq struct {
 	ts         time.Time
+	accepted   *bool   // nil if no reply was wanted
+	replyErr   *string // error from proxying the request, if any
+	latency    time.Duration
+	reqType    string
 	chID       uint32
 	fromClient bool
+	wantReply  bool
 }
 
-func (r commonReq) Insert(tx pgx.Tx, sessionID int) (int, error) {
+func newCommonReq(req *ssh.Request, fromClient bool, chID uint32) *commonReq {
+	return &commonReq{
+		ts:         time.Now(),
+		reqType:    req.Type,
+		chID:       chID,
+		fromClient: fromClient,
+		wantReply:  req.WantReply,
+	}
+}
+
+// setReply sets the reply that was sent to the request, the error
+// proxying it resulted in and how long the proxy took to answer
+func (r *commonReq) setReply(accepted bool, err error, latency time.Duration) {
+	if r.wantReply {
+		r.accepted = &accepted
+	}
+	if err != nil {
+		msg := err.Error()
+		r.replyErr = &msg
+	}
+	r.latency = latency
+}
+
+func (r *commonReq) Insert(tx pgx.Tx, sessionID int) (int, error) {
 	row := tx.QueryRow(context.TODO(), `
-	INSERT INTO Request(session_id, channel_id, ts, from_client)
-		VALUES($1, $2, $3, $4)
+	INSERT INTO Request(session_id, channel_id, ts, from_client, type, want_reply, accepted, reply_error, latency)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
     RETURNING id
-`, sessionID, r.chID, r.ts, r.fromClient)
+`, sessionID, r.chID, r.ts, r.fromClient, db.Sanitize(r.reqType), r.wantReply, r.accepted, r.replyErr, r.latency)
 
 	var id int
 	err := row.Scan(&id)
 	return id, err
 }
 
+// eventData returns the data of the request shared by all types, merged with extra
+func (r *commonReq) eventData(extra map[string]any) map[string]any {
+	data := map[string]any{
+		"from_client": r.fromClient,
+		"type":        r.reqType,
+		"want_reply":  r.wantReply,
+		"latency":     r.latency.Seconds(),
+	}
+	if r.accepted != nil {
+		data["accepted"] = *r.accepted
+	}
+	if r.replyErr != nil {
+		data["reply_error"] = *r.replyErr
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return data
+}
+
 type ptyReq struct {
 	term     string
 	modelist string
@@ -57,7 +107,7 @@ type ptyReq struct {
 	width    uint32
 	height   uint32
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *ptyReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -69,14 +119,24 @@ func (r *ptyReq) Insert(tx pgx.Tx, sessionID int) error {
 	_, err = tx.Exec(context.TODO(), `
 	INSERT INTO PTYRequest(request_id, term, columns, rows, width, height, modelist)
 		VALUES($1, $2, $3, $4, $5, $6, $7)
-`, id, r.term, r.columns, r.rows, r.width, r.height, []byte(r.modelist))
+`, id, db.Sanitize(r.term), r.columns, r.rows, r.width, r.height, []byte(r.modelist))
 	return err
 }
 
+func (r *ptyReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{
+		"term":    r.term,
+		"columns": r.columns,
+		"rows":    r.rows,
+		"width":   r.width,
+		"height":  r.height,
+	})
+}
+
 type execReq struct {
 	command string
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *execReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -88,14 +148,18 @@ func (r *execReq) Insert(tx pgx.Tx, sessionID int) error {
 	_, err = tx.Exec(context.TODO(), `
 	INSERT INTO ExecRequest(request_id, command)
 		VALUES($1, $2)
-`, id, r.command)
+`, id, db.Sanitize(r.command))
 	return err
 }
 
+func (r *execReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"command": r.command})
+}
+
 type exitStatusReq struct {
 	exitStatus uint32
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *exitStatusReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -111,13 +175,17 @@ func (r *exitStatusReq) Insert(tx pgx.Tx, sessionID int) error {
 	return err
 }
 
+func (r *exitStatusReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"exit_status": r.exitStatus})
+}
+
 type exitSignalReq struct {
 	signalName string
 	coreDumped bool
 	errorMsg   string
 	langTag    string
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *exitSignalReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -129,12 +197,20 @@ func (r *exitSignalReq) Insert(tx pgx.Tx, sessionID int) error {
 	_, err = tx.Exec(context.TODO(), `
 	INSERT INTO ExitSignalRequest(request_id, signal_name, core_dumped, error_msg, language_tag)
 		VALUES($1, $2, $3, $4, $5)
-`, id, r.signalName, r.coreDumped, r.errorMsg, r.langTag)
+`, id, db.Sanitize(r.signalName), r.coreDumped, db.Sanitize(r.errorMsg), db.Sanitize(r.langTag))
 	return err
 }
 
+func (r *exitSignalReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{
+		"signal_name": r.signalName,
+		"core_dumped": r.coreDumped,
+		"error_msg":   r.errorMsg,
+	})
+}
+
 type shellReq struct {
-	c commonReq
+	c *commonReq
 }
 
 func (r *shellReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -150,13 +226,17 @@ func (r *shellReq) Insert(tx pgx.Tx, sessionID int) error {
 	return err
 }
 
+func (r *shellReq) eventData() map[string]any {
+	return r.c.eventData(nil)
+}
+
 type windowDimChangeReq struct {
 	columns uint32
 	rows    uint32
 	width   uint32
 	height  uint32
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *windowDimChangeReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -172,11 +252,20 @@ func (r *windowDimChangeReq) Insert(tx pgx.Tx, sessionID int) error {
 	return err
 }
 
+func (r *windowDimChangeReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{
+		"columns": r.columns,
+		"rows":    r.rows,
+		"width":   r.width,
+		"height":  r.height,
+	})
+}
+
 type envReq struct {
 	Name  string
 	Value string
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *envReq) Insert(tx pgx.Tx, sessionID int) error {
@@ -188,14 +277,18 @@ func (r *envReq) Insert(tx pgx.Tx, sessionID int) error {
 	_, err = tx.Exec(context.TODO(), `
 	INSERT INTO EnvironmentRequest(request_id, name, value)
 		VALUES($1, $2, $3)
-`, id, r.Name, r.Value)
+`, id, db.Sanitize(r.Name), db.Sanitize(r.Value))
 	return err
 }
 
+func (r *envReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"name": r.Name, "value": r.Value})
+}
+
 type subSystemRequest struct {
 	Name string
 
-	c commonReq
+	c *commonReq
 }
 
 func (r *subSystemRequest) Insert(tx pgx.Tx, sessionID int) error {
@@ -207,13 +300,159 @@ func (r *subSystemRequest) Insert(tx pgx.Tx, sessionID int) error {
 	_, err = tx.Exec(context.TODO(), `
 	INSERT INTO SubSystemRequest(request_id, name)
 		VALUES($1, $2)
-`, id, r.Name)
+`, id, db.Sanitize(r.Name))
+	return err
+}
+
+func (r *subSystemRequest) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"name": r.Name})
+}
+
+type x11Req struct {
+	authProtocol     string
+	authCookie       string
+	screenNumber     uint32
+	singleConnection bool
+
+	c *commonReq
+}
+
+func (r *x11Req) Insert(tx pgx.Tx, sessionID int) error {
+	id, err := r.c.Insert(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO X11Request(request_id, single_connection, auth_protocol, auth_cookie, screen_number)
+		VALUES($1, $2, $3, $4, $5)
+`, id, r.singleConnection, db.Sanitize(r.authProtocol), db.Sanitize(r.authCookie), r.screenNumber)
+	return err
+}
+
+func (r *x11Req) eventData() map[string]any {
+	return r.c.eventData(map[string]any{
+		"single_connection": r.singleConnection,
+		"auth_protocol":     r.authProtocol,
+		"screen_number":     r.screenNumber,
+	})
+}
+
+type signalReq struct {
+	signalName string
+
+	c *commonReq
+}
+
+func (r *signalReq) Insert(tx pgx.Tx, sessionID int) error {
+	id, err := r.c.Insert(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO SignalRequest(request_id, signal_name)
+		VALUES($1, $2)
+`, id, db.Sanitize(r.signalName))
+	return err
+}
+
+func (r *signalReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"signal_name": r.signalName})
+}
+
+type flowControlReq struct {
+	clientCanDo bool
+
+	c *commonReq
+}
+
+func (r *flowControlReq) Insert(tx pgx.Tx, sessionID int) error {
+	id, err := r.c.Insert(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO FlowControlRequest(request_id, client_can_do)
+		VALUES($1, $2)
+`, id, r.clientCanDo)
 	return err
 }
 
+func (r *flowControlReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"client_can_do": r.clientCanDo})
+}
+
+type breakReq struct {
+	breakLength uint32 // In milliseconds
+
+	c *commonReq
+}
+
+func (r *breakReq) Insert(tx pgx.Tx, sessionID int) error {
+	id, err := r.c.Insert(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO BreakRequest(request_id, break_length)
+		VALUES($1, $2)
+`, id, r.breakLength)
+	return err
+}
+
+func (r *breakReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"break_length": r.breakLength})
+}
+
+// rawReq is a request of an unknown type, or one that could not be parsed
+type rawReq struct {
+	payload []byte
+
+	c *commonReq
+}
+
+func (r *rawReq) Insert(tx pgx.Tx, sessionID int) error {
+	id, err := r.c.Insert(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.TODO(), `
+	INSERT INTO RawRequest(request_id, payload)
+		VALUES($1, $2)
+`, id, r.payload)
+	return err
+}
+
+func (r *rawReq) eventData() map[string]any {
+	return r.c.eventData(map[string]any{"payload": r.payload})
+}
+
+// newRequest parses the request, requests of unknown types
+// or with invalid payloads are returned as raw requests
 // nolint:ireturn // needs to return interfae since is returns a bunch of different types
-func newRequest(req *ssh.Request, fromClient bool, chID uint32, l zerolog.Logger) (request, error) {
-	c := commonReq{ts: time.Now(), chID: chID, fromClient: fromClient}
+func newRequest(req *ssh.Request, c *commonReq, l zerolog.Logger) request {
+	r, err := parseRequest(req, c, l)
+	if err != nil {
+		l.Err(err).Str("type", req.Type).Msg("Could not parse channel request")
+	}
+	if r == nil {
+		l.Info().
+			Str("type", req.Type).
+			Bool("wantReply", req.WantReply).
+			Int("payloadLength", len(req.Payload)).
+			Msg("Got raw channel request")
+		return &rawReq{payload: req.Payload, c: c}
+	}
+	return r
+}
+
+// parseRequest parses the request, returning nil if the type is unknown
+// nolint:ireturn // needs to return interfae since is returns a bunch of different types
+func parseRequest(req *ssh.Request, c *commonReq, l zerolog.Logger) (request, error) {
 	switch req.Type {
 	case PTYRequest:
 		r := struct {
@@ -356,7 +595,69 @@ func newRequest(req *ssh.Request, fromClient bool, chID uint32, l zerolog.Logger
 			Name: r.Name,
 			c:    c,
 		}, nil
+	case X11Request:
+		r := struct {
+			SingleConnection bool
+			AuthProtocol     string
+			AuthCookie       string
+			ScreenNumber     uint32
+		}{}
+		if err := ssh.Unmarshal(req.Payload, &r); err != nil {
+			return nil, err
+		}
+		l.Info().
+			Bool("singleConnection", r.SingleConnection).
+			Str("authProtocol", r.AuthProtocol).
+			Uint32("screenNumber", r.ScreenNumber).
+			Str("type", req.Type).
+			Msg("Got channel request")
+		return &x11Req{
+			singleConnection: r.SingleConnection,
+			authProtocol:     r.AuthProtocol,
+			authCookie:       r.AuthCookie,
+			screenNumber:     r.ScreenNumber,
+			c:                c,
+		}, nil
+	case SignalRequest:
+		r := struct{ SignalName string }{}
+		if err := ssh.Unmarshal(req.Payload, &r); err != nil {
+			return nil, err
+		}
+		l.Info().
+			Str("signalName", r.SignalName).
+			Str("type", req.Type).
+			Msg("Got channel request")
+		return &signalReq{
+			signalName: r.SignalName,
+			c:          c,
+		}, nil
+	case FlowControlRequest:
+		r := struct{ ClientCanDo bool }{}
+		if err := ssh.Unmarshal(req.Payload, &r); err != nil {
+			return nil, err
+		}
+		l.Info().
+			Bool("clientCanDo", r.ClientCanDo).
+			Str("type", req.Type).
+			Msg("Got channel request")
+		return &flowControlReq{
+			clientCanDo: r.ClientCanDo,
+			c:           c,
+		}, nil
+	case BreakRequest:
+		r := struct{ BreakLength uint32 }{}
+		if err := ssh.Unmarshal(req.Payload, &r); err != nil {
+			return nil, err
+		}
+		l.Info().
+			Uint32("breakLength", r.BreakLength).
+			Str("type", req.Type).
+			Msg("Got channel request")
+		return &breakReq{
+			breakLength: r.BreakLength,
+			c:           c,
+		}, nil
 	default:
-		return nil, fmt.Errorf("request %q not supported", req.Type)
+		return nil, nil
 	}
 }
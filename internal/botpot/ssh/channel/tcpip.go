@@ -0,0 +1,46 @@
+package channel
+
+import (
+	"context"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// Channel types used for port forwarding - RFC 4254 7.X
+const (
+	ForwardedTCPIPChannel = "forwarded-tcpip" // RFC 4254 7.2
+	DirectTCPIPChannel    = "direct-tcpip"    // RFC 4254 7.2
+)
+
+// tcpip is the extra data of a direct-tcpip or forwarded-tcpip channel.
+// For direct-tcpip the address is where the client wants to connect,
+// for forwarded-tcpip it is the address of the port that was connected to.
+type tcpip struct {
+	Address           string
+	Port              uint32
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+// parseTCPIP parses the extra data of the channel if it is used for port forwarding
+func parseTCPIP(channelType string, extraData []byte) (*tcpip, error) {
+	if channelType != DirectTCPIPChannel && channelType != ForwardedTCPIPChannel {
+		return nil, nil
+	}
+
+	t := &tcpip{}
+	if err := ssh.Unmarshal(extraData, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tcpip) Insert(tx pgx.Tx, sessionID int, channelID uint32, action string) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO TCPIPChannel(session_id, channel_id, address, port, originator_address, originator_port, action)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+`, sessionID, channelID, db.Sanitize(t.Address), t.Port, db.Sanitize(t.OriginatorAddress), t.OriginatorPort, action)
+	return err
+}
@@ -0,0 +1,145 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxWatchBacklog is how many bytes a watcher may fall behind before it is cut off
+const maxWatchBacklog = 4 << 20
+
+// ErrWatcherBehind is returned to watchers that fell too far behind the channel
+var ErrWatcherBehind = errors.New("watcher fell behind the channel")
+
+// Watcher receives the data proxied over a channel as it is sent.
+// Nothing is dropped, a watcher that falls more than maxWatchBacklog
+// bytes behind is cut off instead.
+type Watcher struct {
+	err     error // Set once no more data will be queued
+	pending []byte
+	ready   chan struct{}
+	mu      sync.Mutex
+	input   bool
+}
+
+// Read returns the data queued since the last call, waiting for some to arrive.
+// io.EOF is returned once the channel has closed and all data has been read.
+func (w *Watcher) Read(ctx context.Context) ([]byte, error) {
+	for {
+		w.mu.Lock()
+		if len(w.pending) > 0 {
+			data := w.pending
+			w.pending = nil
+			w.mu.Unlock()
+			return data, nil
+		}
+		err := w.err
+		w.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.ready:
+		}
+	}
+}
+
+func (w *Watcher) queue(p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	if len(w.pending)+len(p) > maxWatchBacklog {
+		w.pending = nil
+		w.err = ErrWatcherBehind
+	} else {
+		w.pending = append(w.pending, p...)
+	}
+	w.signal()
+}
+
+func (w *Watcher) stop() {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = io.EOF
+	}
+	w.signal()
+	w.mu.Unlock()
+}
+
+func (w *Watcher) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// watchers are the watchers of a channel
+type watchers struct {
+	set    map[*Watcher]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// add registers a new watcher, which also receives the data
+// sent by the client if input is true
+func (ws *watchers) add(input bool) *Watcher {
+	w := &Watcher{ready: make(chan struct{}, 1), input: input}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		w.err = io.EOF
+		return w
+	}
+	if ws.set == nil {
+		ws.set = make(map[*Watcher]struct{})
+	}
+	ws.set[w] = struct{}{}
+	return w
+}
+
+func (ws *watchers) remove(w *Watcher) {
+	ws.mu.Lock()
+	delete(ws.set, w)
+	ws.mu.Unlock()
+	w.stop()
+}
+
+func (ws *watchers) send(fromClient bool, p []byte) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.set {
+		if !fromClient || w.input {
+			w.queue(p)
+		}
+	}
+}
+
+// close stops all watchers once the channel has closed
+func (ws *watchers) close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.closed = true
+	for w := range ws.set {
+		w.stop()
+	}
+	ws.set = nil
+}
+
+// watchTee passes the data read in one direction of a channel to its watchers
+type watchTee struct {
+	watchers   *watchers
+	fromClient bool
+}
+
+// Write implements the io.Writer interface
+func (t watchTee) Write(p []byte) (int, error) {
+	t.watchers.send(t.fromClient, p)
+	return len(p), nil
+}
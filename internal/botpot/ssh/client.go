@@ -8,6 +8,9 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/artifact"
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
 	"github.com/alx99/botpot/internal/botpot/ssh/channel"
 	"github.com/alx99/botpot/internal/botpot/ssh/session"
 	"github.com/rs/zerolog"
@@ -22,17 +25,20 @@ type client struct {
 	proxy        sshProxy
 	l            zerolog.Logger
 	session      session.Session
+	console      Console
+	hostID       string
 	chanCounter  uint32
 	disconnected atomic.Bool
 	wg           sync.WaitGroup
+	chanMu       sync.Mutex // Channels are added from multiple goroutines
 }
 
-func newClient(conn ssh.Conn, proxy sshProxy, channelChan <-chan ssh.NewChannel) *client {
+func newClient(conn ssh.Conn, proxy sshProxy, channelChan <-chan ssh.NewChannel, database db.Store, store artifact.Store, events *event.Dispatcher, console Console, limits channel.Limits, forward channel.ForwardPolicy) *client {
 	l := log.With().
 		Str("rAddr", conn.RemoteAddr().String()).
 		Logger()
 
-	s := session.NewSession(conn.RemoteAddr(), conn.LocalAddr(), string(conn.ClientVersion()), l)
+	s := session.NewSession(conn.RemoteAddr(), conn.LocalAddr(), string(conn.ClientVersion()), database, store, events, limits, forward, l)
 	c := client{
 		conn:         conn,
 		rAddr:        conn.RemoteAddr(),
@@ -40,6 +46,7 @@ func newClient(conn ssh.Conn, proxy sshProxy, channelChan <-chan ssh.NewChannel)
 		proxy:        proxy,
 		l:            l,
 		session:      s,
+		console:      console,
 		chanCounter:  0,
 		disconnected: atomic.Bool{},
 		wg:           sync.WaitGroup{},
@@ -61,9 +68,11 @@ func (c *client) handle(reqChan <-chan *ssh.Request) {
 	}
 	c.l.Info().Str("duration", time.Since(t).String()).Msg("Connected to proxy")
 
-	c.wg.Add(2)
+	c.wg.Add(4)
 	go c.handleChannels()
-	go c.handleGlobalRequests(c.proxy.client, reqChan, true) // client to proxy
+	go c.handleForwards()
+	go c.handleGlobalRequests(c.proxy.client, reqChan, true)   // client to proxy
+	go c.handleGlobalRequests(c.conn, c.proxy.requests, false) // proxy to client
 
 	// Wait for proxy to disconnect
 	go func() {
@@ -103,42 +112,73 @@ func (c *client) handle(reqChan <-chan *ssh.Request) {
 // handleChannels handles channel requests from the client
 func (c *client) handleChannels() {
 	for chanReq := range c.channelchan {
-		ch := channel.NewChannel(atomic.AddUint32(&c.chanCounter, 1), chanReq, c.proxy.client, c.l)
+		ch := c.session.NewChannel(atomic.AddUint32(&c.chanCounter, 1), chanReq, true, c.proxy.client)
 		ch.Handle()
-		c.session.AddChannel(ch)
+		c.addChannel(ch)
 	}
 	c.wg.Done()
 }
 
-// handleGlobalRequests proxies global requests from the client to an SSH server
-func (c *client) handleGlobalRequests(client *ssh.Client, reqChan <-chan *ssh.Request, fromClient bool) {
+// handleForwards handles channels opened by the proxy for ports forwarded by the client
+func (c *client) handleForwards() {
+	for chanReq := range c.proxy.forwards {
+		ch := c.session.NewChannel(atomic.AddUint32(&c.chanCounter, 1), chanReq, false, c.conn)
+		ch.Handle()
+		c.addChannel(ch)
+	}
+	c.wg.Done()
+}
+
+func (c *client) addChannel(ch *channel.Channel) {
+	c.chanMu.Lock()
+	c.session.AddChannel(ch)
+	c.chanMu.Unlock()
+	c.console.Add(c.session.UID(), ch)
+}
+
+// handleGlobalRequests proxies global requests from one side of the connection to the other
+func (c *client) handleGlobalRequests(conn ssh.Conn, reqChan <-chan *ssh.Request, fromClient bool) {
 	for req := range reqChan {
-		// This we actually ignore because this will give us
-		// side-effects if our proxy respects this request
-		if req.Type == "no-more-sessions@openssh.com" {
-			continue
-		}
+		r := session.NewGlobalRequest(req, fromClient)
+		c.proxyGlobalRequest(conn, req, r, fromClient)
+		c.session.AddGlobalRequest(r)
+	}
+	c.wg.Done()
+}
 
-		c.l.Debug().Interface("req", req).Send()
+func (c *client) proxyGlobalRequest(conn ssh.Conn, req *ssh.Request, r *session.GlobalRequest, fromClient bool) {
+	c.l.Debug().Str("type", req.Type).Bool("wantReply", req.WantReply).Bool("fromClient", fromClient).
+		Msg("Got global request")
 
-		ok, res, err := client.SendRequest(req.Type, req.WantReply, req.Payload)
-		if err != nil {
-			c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to proxy request")
-			if !req.WantReply {
-				continue
-			}
-			if err = req.Reply(false, res); err != nil {
+	// This we actually ignore because this will give us
+	// side-effects if our proxy respects this request
+	if req.Type == "no-more-sessions@openssh.com" {
+		return
+	}
+
+	// The host keys of the host differ from ours and we can not prove
+	// that we own them, which would give away the honeypot
+	if strings.HasPrefix(req.Type, "hostkeys-") {
+		r.SetReply(false, nil)
+		if req.WantReply {
+			if err := req.Reply(false, nil); err != nil {
 				c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to reply to request")
 			}
-			continue
 		}
+		return
+	}
 
-		if !req.WantReply {
-			continue
-		}
-		if err = req.Reply(ok, res); err != nil {
-			c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to reply to request")
-		}
+	ok, res, err := conn.SendRequest(req.Type, req.WantReply, req.Payload)
+	if err != nil {
+		c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to proxy request")
+		ok, res = false, nil
+	}
+	r.SetReply(ok, res)
+
+	if !req.WantReply {
+		return
+	}
+	if err = req.Reply(ok, res); err != nil {
+		c.l.Err(err).Bool("fromClient", fromClient).Msg("Failed to reply to request")
 	}
-	c.wg.Done()
 }
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"github.com/alx99/botpot/internal/botpot/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_ssh_connections_total",
+		Help: "Connections accepted by the SSH server.",
+	})
+	handshakeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_ssh_handshake_failures_total",
+		Help: "Connections that did not complete the SSH handshake.",
+	})
+	handshakeSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botpot_ssh_handshake_seconds",
+		Help:    "Time taken by the SSH handshake, including authentication.",
+		Buckets: metrics.LatencyBuckets,
+	})
+	authAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "botpot_ssh_auth_attempts_total",
+		Help: "Authentication attempts by method and outcome.",
+	}, []string{"method", "accepted"})
+	hostFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_host_failures_total",
+		Help: "Sessions dropped because no host could be obtained.",
+	})
+	activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "botpot_sessions_active",
+		Help: "Sessions currently connected.",
+	})
+	proxyConnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_proxy_connects_total",
+		Help: "Connections made from the proxy to a host.",
+	})
+	proxyRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_proxy_connect_retries_total",
+		Help: "Retried connection attempts from the proxy to a host.",
+	})
+	proxyFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "botpot_proxy_connect_failures_total",
+		Help: "Connections from the proxy to a host that gave up.",
+	})
+	proxySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botpot_proxy_connect_seconds",
+		Help:    "Time taken by the proxy to connect to a host, including retries.",
+		Buckets: metrics.LatencyBuckets,
+	})
+)
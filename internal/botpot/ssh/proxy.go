@@ -1,8 +1,10 @@
 package ssh
 
 import (
+	"net"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/ssh/channel"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -12,16 +14,23 @@ type sshProxy struct {
 	cfg     *ssh.ClientConfig
 	client  *ssh.Client
 	session *ssh.Session
-	host    string
+	// forwards are the channels opened by the SSH server
+	// for ports forwarded by the client
+	forwards <-chan ssh.NewChannel
+	// requests are the global requests sent by the SSH server
+	requests <-chan *ssh.Request
+	host     string
 }
 
-func newSSHProxy(host, user string) sshProxy {
+func newSSHProxy(host, user, password string) sshProxy {
 	p := sshProxy{host: host}
 
 	p.cfg = &ssh.ClientConfig{
 		User:            user,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Auth:            []ssh.AuthMethod{}, // No auth
+		// Users without a password are let in by
+		// the "none" method which is always tried first
+		Auth: []ssh.AuthMethod{ssh.Password(password)},
 	}
 	return p
 }
@@ -30,18 +39,40 @@ func newSSHProxy(host, user string) sshProxy {
 func (p *sshProxy) Connect() error {
 	var err error
 	connect := func() error {
-		p.client, err = ssh.Dial("tcp", p.host, p.cfg)
+		conn, err := net.DialTimeout("tcp", p.host, p.cfg.Timeout)
 		if err != nil {
 			return err
 		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, p.host, p.cfg)
+		if err != nil {
+			// nolint:errcheck // the handshake error is what matters
+			conn.Close()
+			return err
+		}
+
+		// Global requests from the server are proxied to the
+		// client instead of being rejected by ssh.Client
+		noRequests := make(chan *ssh.Request)
+		close(noRequests)
+		p.client = ssh.NewClient(c, chans, noRequests)
+		p.requests = reqs
+		p.forwards = p.client.HandleChannelOpen(channel.ForwardedTCPIPChannel)
 
 		p.session, err = p.client.NewSession()
+		if err != nil {
+			// nolint:errcheck // the session error is what matters
+			p.client.Close()
+		}
 		return err
 	}
 
 	// Try to connect for 10s
-	end := time.Now().Add(10 * time.Second)
-	for time.Now().Before(end) {
+	start := time.Now()
+	end := start.Add(10 * time.Second)
+	for attempt := 0; time.Now().Before(end); attempt++ {
+		if attempt > 0 {
+			proxyRetries.Inc()
+		}
 		if err = connect(); err != nil {
 			time.Sleep(100 * time.Millisecond)
 			continue
@@ -49,7 +80,13 @@ func (p *sshProxy) Connect() error {
 		break
 	}
 
-	return err
+	proxyConnects.Inc()
+	if err != nil {
+		proxyFailures.Inc()
+		return err
+	}
+	proxySeconds.Observe(time.Since(start).Seconds())
+	return nil
 }
 
 // Wait blocks until the connection has shut down, and returns the
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthAttempt represents an authentication attempt made by the client
+type AuthAttempt interface {
+	Insert(tx pgx.Tx, sessionID int) error
+	eventData() (time.Time, map[string]any)
+}
+
+type commonAuth struct {
+	ts            time.Time
+	username      string
+	clientVersion string
+	attempt       int
+	accepted      bool
+}
+
+func newCommonAuth(conn ssh.ConnMetadata, attempt int, accepted bool) commonAuth {
+	return commonAuth{
+		ts:            time.Now(),
+		username:      conn.User(),
+		clientVersion: string(conn.ClientVersion()),
+		attempt:       attempt,
+		accepted:      accepted,
+	}
+}
+
+func (c commonAuth) eventData(method string) map[string]any {
+	return map[string]any{
+		"method":         method,
+		"username":       c.username,
+		"client_version": c.clientVersion,
+		"attempt":        c.attempt,
+		"accepted":       c.accepted,
+	}
+}
+
+// Credential represents a password authentication attempt
+type Credential struct {
+	Password string
+
+	c commonAuth
+}
+
+// NewCredential creates a new credential
+func NewCredential(conn ssh.ConnMetadata, password string, attempt int, accepted bool) *Credential {
+	return &Credential{
+		Password: password,
+		c:        newCommonAuth(conn, attempt, accepted),
+	}
+}
+
+// Insert tries to insert the data into the database
+func (a *Credential) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO Credential(session_id, attempt, accepted, username, password, client_version, ts)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+`, sessionID, a.c.attempt, a.c.accepted, db.Sanitize(a.c.username), db.Sanitize(a.Password), db.Sanitize(a.c.clientVersion), a.c.ts)
+	return err
+}
+
+func (a *Credential) eventData() (time.Time, map[string]any) {
+	data := a.c.eventData("password")
+	data["password"] = a.Password
+	return a.c.ts, data
+}
+
+// PublicKey represents a public key authentication attempt
+type PublicKey struct {
+	KeyType       string
+	Fingerprint   string
+	AuthorizedKey string
+
+	c commonAuth
+}
+
+// NewPublicKey creates a new public key
+func NewPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey, attempt int, accepted bool) *PublicKey {
+	return &PublicKey{
+		KeyType:       key.Type(),
+		Fingerprint:   ssh.FingerprintSHA256(key),
+		AuthorizedKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		c:             newCommonAuth(conn, attempt, accepted),
+	}
+}
+
+// Insert tries to insert the data into the database
+func (a *PublicKey) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO PublicKey(session_id, attempt, accepted, username, key_type, fingerprint, authorized_key, client_version, ts)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, sessionID, a.c.attempt, a.c.accepted, db.Sanitize(a.c.username), a.KeyType, a.Fingerprint, a.AuthorizedKey, db.Sanitize(a.c.clientVersion), a.c.ts)
+	return err
+}
+
+func (a *PublicKey) eventData() (time.Time, map[string]any) {
+	data := a.c.eventData("publickey")
+	data["key_type"] = a.KeyType
+	data["fingerprint"] = a.Fingerprint
+	return a.c.ts, data
+}
+
+// KeyboardInteractive represents a keyboard-interactive authentication attempt
+type KeyboardInteractive struct {
+	Instruction string
+	Questions   []string
+	Answers     []string
+
+	c commonAuth
+}
+
+// NewKeyboardInteractive creates a new keyboard-interactive attempt
+func NewKeyboardInteractive(conn ssh.ConnMetadata, instruction string, questions, answers []string, attempt int, accepted bool) *KeyboardInteractive {
+	return &KeyboardInteractive{
+		Instruction: instruction,
+		Questions:   questions,
+		Answers:     answers,
+		c:           newCommonAuth(conn, attempt, accepted),
+	}
+}
+
+// Insert tries to insert the data into the database
+func (a *KeyboardInteractive) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO KeyboardInteractive(session_id, attempt, accepted, username, instruction, questions, answers, client_version, ts)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, sessionID, a.c.attempt, a.c.accepted, db.Sanitize(a.c.username), db.Sanitize(a.Instruction), db.SanitizeAll(a.Questions),
+		db.SanitizeAll(a.Answers), db.Sanitize(a.c.clientVersion), a.c.ts)
+	return err
+}
+
+func (a *KeyboardInteractive) eventData() (time.Time, map[string]any) {
+	data := a.c.eventData("keyboard-interactive")
+	data["questions"] = a.Questions
+	data["answers"] = a.Answers
+	return a.c.ts, data
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// Global request types used for port forwarding - RFC 4254 7.1
+const (
+	TCPIPForwardRequest       = "tcpip-forward"
+	CancelTCPIPForwardRequest = "cancel-tcpip-forward"
+)
+
+// GlobalRequest represents a global request sent by either side of the connection
+type GlobalRequest struct {
+	ts          time.Time
+	bindAddress *string // Only set for port forwarding requests
+	bindPort    *uint32
+	boundPort   *uint32 // Port allocated by the server when bindPort is 0
+	reqType     string
+	payload     []byte
+	wantReply   bool
+	fromClient  bool
+	accepted    bool
+}
+
+// NewGlobalRequest creates a new global request
+func NewGlobalRequest(req *ssh.Request, fromClient bool) *GlobalRequest {
+	r := &GlobalRequest{
+		ts:         time.Now(),
+		reqType:    req.Type,
+		payload:    req.Payload,
+		wantReply:  req.WantReply,
+		fromClient: fromClient,
+	}
+
+	if req.Type == TCPIPForwardRequest || req.Type == CancelTCPIPForwardRequest {
+		p := struct {
+			BindAddress string
+			BindPort    uint32
+		}{}
+		if err := ssh.Unmarshal(req.Payload, &p); err == nil {
+			bindAddress := db.Sanitize(p.BindAddress)
+			r.bindAddress, r.bindPort = &bindAddress, &p.BindPort
+		}
+	}
+	return r
+}
+
+// Type returns the type of the request
+func (r *GlobalRequest) Type() string {
+	return r.reqType
+}
+
+// SetReply sets the reply that was sent to the request
+func (r *GlobalRequest) SetReply(accepted bool, payload []byte) {
+	r.accepted = accepted
+	if accepted && r.reqType == TCPIPForwardRequest && r.bindPort != nil && *r.bindPort == 0 && len(payload) >= 4 {
+		port := binary.BigEndian.Uint32(payload)
+		r.boundPort = &port
+	}
+}
+
+// Insert tries to insert the data into the database
+func (r *GlobalRequest) Insert(tx pgx.Tx, sessionID int) error {
+	_, err := tx.Exec(context.TODO(), `
+	INSERT INTO GlobalRequest(session_id, ts, from_client, type, want_reply, accepted, bind_address, bind_port, bound_port, payload)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`, sessionID, r.ts, r.fromClient, db.Sanitize(r.reqType), r.wantReply, r.accepted, r.bindAddress, r.bindPort, r.boundPort, r.payload)
+	return err
+}
+
+func (r *GlobalRequest) eventData() map[string]any {
+	data := map[string]any{
+		"from_client": r.fromClient,
+		"type":        r.reqType,
+		"want_reply":  r.wantReply,
+		"accepted":    r.accepted,
+	}
+	if r.bindAddress != nil {
+		data["bind_address"] = *r.bindAddress
+		data["bind_port"] = *r.bindPort
+	}
+	if r.boundPort != nil {
+		data["bound_port"] = *r.boundPort
+	}
+	return data
+}
@@ -3,39 +3,96 @@ package session
 import (
 	"context"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/artifact"
+	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
 	"github.com/alx99/botpot/internal/botpot/ssh/channel"
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
 )
 
+// flushInterval is how often global requests are flushed to the database
+const flushInterval = time.Second
+
 // Session represents the database table
 type Session struct {
-	start    time.Time
-	end      time.Time
-	srcIP    string
-	dstIP    string
-	version  string
-	stdout   string
-	timing   string
-	l        zerolog.Logger
-	channels []*channel.Channel
-	srcPort  int
-	dstPort  int
+	start     time.Time
+	end       time.Time
+	db        db.Store
+	store     artifact.Store
+	events    event.Emitter
+	budget    *channel.Budget
+	srcIP     string
+	dstIP     string
+	version   string
+	stdout    string
+	timing    string
+	l         zerolog.Logger
+	channels  []*channel.Channel
+	auths     []AuthAttempt
+	artifacts []artifact.Artifact
+	limits    channel.Limits
+	forward   channel.ForwardPolicy
+	reqs      *pendingRequests
+	flushed   chan struct{} // Closed once the flush loop has returned
+	stop      chan struct{}
+	id        int
+	srcPort   int
+	dstPort   int
+}
+
+// pendingRequests holds the global requests until they are flushed
+type pendingRequests struct {
+	reqs []*GlobalRequest
+	mu   sync.Mutex
+}
+
+func (p *pendingRequests) add(r *GlobalRequest) {
+	p.mu.Lock()
+	p.reqs = append(p.reqs, r)
+	p.mu.Unlock()
+}
+
+// pending returns the requests that have not yet been flushed
+func (p *pendingRequests) pending() []*GlobalRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reqs
+}
+
+// markFlushed forgets the first n pending requests
+func (p *pendingRequests) markFlushed(n int) {
+	p.mu.Lock()
+	p.reqs = append([]*GlobalRequest(nil), p.reqs[n:]...)
+	p.mu.Unlock()
 }
+
 type ipInfo struct {
 	ip   string
 	port int
 }
 
 // NewSession creates a new session
-func NewSession(srcIP, dstIP net.Addr, version string, l zerolog.Logger) Session {
+func NewSession(srcIP, dstIP net.Addr, version string, database db.Store, store artifact.Store, events *event.Dispatcher, limits channel.Limits, forward channel.ForwardPolicy, l zerolog.Logger) Session {
 	s := Session{
 		start:    time.Now(),
 		version:  version,
+		db:       database,
+		store:    store,
+		events:   event.NewEmitter(events, event.NewSessionID()),
+		budget:   channel.NewBudget(limits.Session),
+		limits:   limits,
+		forward:  forward,
 		l:        l,
 		channels: []*channel.Channel{},
+		auths:    []AuthAttempt{},
+		reqs:     &pendingRequests{},
+		flushed:  make(chan struct{}),
+		stop:     make(chan struct{}),
 	}
 
 	i := getIPInfo(srcIP)
@@ -48,6 +105,26 @@ func NewSession(srcIP, dstIP net.Addr, version string, l zerolog.Logger) Session
 	return s
 }
 
+// ID returns the database ID of the session
+func (s *Session) ID() int {
+	return s.id
+}
+
+// UID returns the ID identifying the session in all event sinks
+func (s *Session) UID() string {
+	return s.events.Session()
+}
+
+// NewChannel creates a new channel belonging to the session which will be opened on peer
+func (s *Session) NewChannel(id uint32, req ssh.NewChannel, fromClient bool, peer ssh.Conn) *channel.Channel {
+	return channel.NewChannel(id, s.id, req, fromClient, peer, s.db, s.store, s.events, s.limits, s.forward, s.budget, s.l)
+}
+
+// Started returns when the client connected
+func (s *Session) Started() time.Time {
+	return s.start
+}
+
 // AddScriptOutput adds the script output to the session
 func (s *Session) AddScriptOutput(stdout, timing string) {
 	s.stdout = stdout
@@ -59,8 +136,127 @@ func (s *Session) AddChannel(ch *channel.Channel) {
 	s.channels = append(s.channels, ch)
 }
 
-// Insert tries to insert the data into the database
-func (s *Session) Insert(tx pgx.Tx) error {
+// AddArtifacts adds the files left behind on the host
+func (s *Session) AddArtifacts(artifacts ...artifact.Artifact) {
+	s.artifacts = append(s.artifacts, artifacts...)
+}
+
+// AddAuthAttempts adds the authentication attempts made by the client
+func (s *Session) AddAuthAttempts(attempts ...AuthAttempt) {
+	s.auths = append(s.auths, attempts...)
+}
+
+// AddGlobalRequest adds a global request sent by either side of the
+// connection, it is inserted into the database by the next flush
+func (s *Session) AddGlobalRequest(r *GlobalRequest) {
+	s.events.EmitAt(r.ts, event.GlobalRequest, 0, r.eventData())
+	s.reqs.add(r)
+}
+
+// Start inserts the session and its authentication attempts into the database
+// and starts flushing the global requests. The session has to be aborted if
+// it could not be inserted, since nothing else of it can be stored without it.
+func (s *Session) Start() error {
+	s.events.EmitAt(s.start, event.Connect, 0, map[string]any{
+		"src_ip":   s.srcIP,
+		"src_port": s.srcPort,
+		"dst_ip":   s.dstIP,
+		"dst_port": s.dstPort,
+		"version":  s.version,
+	})
+	for _, a := range s.auths {
+		ts, data := a.eventData()
+		s.events.EmitAt(ts, event.AuthAttempt, 0, data)
+	}
+
+	if err := s.db.BeginTx(s.insert); err != nil {
+		return err
+	}
+	go s.flushLoop()
+	return nil
+}
+
+// flushLoop periodically flushes the global requests
+// to the database until the session is closed
+func (s *Session) flushLoop() {
+	defer close(s.flushed)
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush inserts the global requests that have not yet been flushed
+func (s *Session) flush() {
+	reqs := s.reqs.pending()
+	if len(reqs) == 0 {
+		return
+	}
+	if err := s.db.BeginTx(func(tx pgx.Tx) error { return s.insertRequests(tx, reqs) }); err != nil {
+		s.l.Err(err).Msg("Could not flush global requests to DB")
+		return
+	}
+	s.reqs.markFlushed(len(reqs))
+}
+
+func (s *Session) insertRequests(tx pgx.Tx, reqs []*GlobalRequest) error {
+	for _, r := range reqs {
+		if err := r.Insert(tx, s.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops an active session
+func (s *Session) Stop() {
+	s.l.Info().Msg("Disconnected")
+	s.end = time.Now()
+	s.events.EmitAt(s.end, event.Disconnect, 0, map[string]any{
+		"duration": s.end.Sub(s.start).Seconds(),
+	})
+}
+
+// Close waits for all channels to be written to the database and then
+// updates the session with its end time, script output, artifacts and
+// the remaining global requests. Close must only be called once Start
+// succeeded.
+func (s *Session) Close() error {
+	for _, ch := range s.channels {
+		ch.Wait()
+	}
+	close(s.stop)
+	<-s.flushed
+
+	reqs := s.reqs.pending()
+	return s.db.BeginTx(func(tx pgx.Tx) error {
+		if err := s.insertRequests(tx, reqs); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.TODO(), `
+	UPDATE Session SET end_ts = $1, stdout = $2, timing = $3 WHERE id = $4
+`, s.end, s.stdout, s.timing, s.id)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range s.artifacts {
+			if err = a.Insert(tx, s.id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insert tries to insert the data into the database
+func (s *Session) insert(tx pgx.Tx) error {
 	_, err := tx.Exec(context.TODO(), `
 	INSERT INTO IP(ip_address)
 		VALUES ($1)
@@ -71,31 +267,26 @@ func (s *Session) Insert(tx pgx.Tx) error {
 	}
 
 	row := tx.QueryRow(context.TODO(), `
-	INSERT INTO Session(version, src_ip, src_port, dst_ip, dst_port, start_ts, end_ts, stdout, timing)
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	INSERT INTO Session(uid, version, src_ip, src_port, dst_ip, dst_port, start_ts)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
     RETURNING id
-`, s.version, s.srcIP, s.srcPort, s.dstIP, s.dstPort, s.start, s.end, s.stdout, s.timing)
+`, s.UID(), db.Sanitize(s.version), s.srcIP, s.srcPort, s.dstIP, s.dstPort, s.start)
 
 	var id int
 	if err := row.Scan(&id); err != nil {
 		return err
 	}
 
-	for _, ch := range s.channels {
-		if err := ch.Insert(tx, id); err != nil {
+	for _, a := range s.auths {
+		if err := a.Insert(tx, id); err != nil {
 			return err
 		}
 	}
 
+	s.id = id
 	return nil
 }
 
-// Stop stops an active session
-func (s *Session) Stop() {
-	s.l.Info().Msg("Disconnected")
-	s.end = time.Now()
-}
-
 func getIPInfo(ip net.Addr) ipInfo {
 	i := ipInfo{}
 	switch addr := ip.(type) {
@@ -5,45 +5,104 @@ import (
 	"errors"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/alx99/botpot/internal/botpot/artifact"
 	"github.com/alx99/botpot/internal/botpot/db"
+	"github.com/alx99/botpot/internal/botpot/event"
 	"github.com/alx99/botpot/internal/botpot/hostprovider"
+	"github.com/alx99/botpot/internal/botpot/ssh/auth"
+	"github.com/alx99/botpot/internal/botpot/ssh/channel"
+	"github.com/alx99/botpot/internal/botpot/ssh/session"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/ssh"
 )
 
+var (
+	errPermissionDenied = errors.New("permission denied")
+	// ErrUnknownSession is returned when acting on a session that is not connected
+	ErrUnknownSession = errors.New("unknown session")
+)
+
+// Console is where channels are made available to operators while they are live
+type Console interface {
+	Add(session string, ch *channel.Channel)
+}
+
+// handshakeTimeout is how long a client gets to complete the handshake, including authentication
+const handshakeTimeout = 2 * time.Minute
+
+// hostTimeout is how long getting a host ready for a client may take
+const hostTimeout = 30 * time.Second
+
+// passwordExtension is the permission extension holding the password
+// the client successfully authenticated with
+const passwordExtension = "botpot-password"
+
 // Server serves SSH connections from attackers
 type Server struct {
 	l         net.Listener
 	provider  hostprovider.SSH
+	policy    auth.Policy
+	limits    channel.Limits
+	forward   channel.ForwardPolicy
+	store     artifact.Store
+	events    *event.Dispatcher
+	console   Console
 	cfg       *ssh.ServerConfig
-	db        *db.DB
+	db        db.Store
+	clients   map[string]*client
 	keypaths  []string
+	auth      authLog
 	port      int
 	lIsClosed atomic.Bool
+	draining  atomic.Bool
 	wg        sync.WaitGroup
+	clientsMu sync.Mutex
+}
+
+// SessionInfo describes a connected session
+type SessionInfo struct {
+	Started time.Time `json:"started"`
+	UID     string    `json:"session"`
+	Addr    string    `json:"addr"`
+	User    string    `json:"user"`
+	Version string    `json:"version"`
+	HostID  string    `json:"host_id"`
 }
 
 // New creates a new SSH server
-func New(serverVersion string, port int, keyPaths []string, provider hostprovider.SSH, database *db.DB) *Server {
+func New(serverVersion string, port int, keyPaths []string, provider hostprovider.SSH, policy auth.Policy, limits channel.Limits, forward channel.ForwardPolicy, store artifact.Store, database db.Store, events *event.Dispatcher, console Console) *Server {
 	s := &Server{
 		l:        nil,
 		provider: provider,
+		policy:   policy,
+		limits:   limits,
+		forward:  forward,
+		store:    store,
+		events:   events,
+		console:  console,
 		cfg:      &ssh.ServerConfig{},
 		db:       database,
+		clients:  make(map[string]*client),
 		port:     port,
 		keypaths: keyPaths,
+		auth:     newAuthLog(),
 		wg:       sync.WaitGroup{},
 	}
 	s.cfg = &ssh.ServerConfig{
-		NoClientAuth:     true,
-		MaxAuthTries:     999,
-		ServerVersion:    serverVersion,
-		PasswordCallback: s.pwCallback,
+		// Require the client to authenticate so that we get
+		// to record the credentials it offers
+		NoClientAuth:                false,
+		MaxAuthTries:                999,
+		ServerVersion:               serverVersion,
+		PasswordCallback:            s.pwCallback,
+		PublicKeyCallback:           s.pubKeyCallback,
+		KeyboardInteractiveCallback: s.keyboardInteractiveCallback,
 	}
 
 	return s
@@ -100,37 +159,128 @@ func (s *Server) loop() {
 			}
 			continue
 		}
-		s.handleClient(conn)
+		if s.draining.Load() {
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleClient(conn)
+		}()
+	}
+}
+
+// SetDraining sets whether new connections are refused. Connected
+// sessions are unaffected, so that botpot can be restarted once they end.
+func (s *Server) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// Draining returns whether new connections are refused
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Sessions returns the connected sessions, oldest first
+func (s *Server) Sessions() []SessionInfo {
+	s.clientsMu.Lock()
+	res := make([]SessionInfo, 0, len(s.clients))
+	for uid, c := range s.clients {
+		res = append(res, SessionInfo{
+			Started: c.session.Started(),
+			UID:     uid,
+			Addr:    c.rAddr.String(),
+			User:    c.conn.User(),
+			Version: string(c.conn.ClientVersion()),
+			HostID:  c.hostID,
+		})
+	}
+	s.clientsMu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+	return res
+}
+
+// Disconnect disconnects the client of a session
+func (s *Server) Disconnect(uid string) error {
+	s.clientsMu.Lock()
+	c, ok := s.clients[uid]
+	s.clientsMu.Unlock()
+	if !ok {
+		return ErrUnknownSession
 	}
+	c.l.Info().Msg("Forcibly disconnecting")
+	return c.conn.Close()
 }
 
 func (s *Server) handleClient(conn net.Conn) {
-	// Handshake connection
+	connectionsTotal.Inc()
+
+	// Handshake connection, giving up on clients that never finish authenticating
 	t := time.Now()
+	if err := conn.SetDeadline(t.Add(handshakeTimeout)); err != nil {
+		log.Err(err).Msg("Could not set handshake deadline")
+	}
 	sshConn, channelChan, reqChan, err := ssh.NewServerConn(conn, s.cfg)
+	attempts := s.auth.pop(conn.RemoteAddr().String())
 	if err != nil {
-		log.Err(err).Msg("Could not handshake SSH connection")
+		handshakeFailures.Inc()
+		log.Err(err).Int("authAttempts", len(attempts)).Msg("Could not handshake SSH connection")
 		conn.Close()
 		return
 	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		log.Err(err).Msg("Could not clear handshake deadline")
+	}
+	handshakeSeconds.Observe(time.Since(t).Seconds())
 	log.Debug().Str("duration", time.Since(t).String()).Msg("Connection handshaked")
 
 	t = time.Now()
-	host, ID, err := s.provider.GetHost(context.TODO())
+	ctx, cancel := context.WithTimeout(context.Background(), hostTimeout)
+	defer cancel()
+	host, ID, err := s.provider.GetHost(ctx)
 	if err != nil {
+		hostFailures.Inc()
 		log.Err(err).Msg("Could not get a hold of an SSH host")
 		conn.Close()
 		return
 	}
 	log.Debug().Str("duration", time.Since(t).String()).Msg("Host obtained")
 
+	// Log in to the host as the same user as the attacker did
+	user, password := sshConn.User(), sshConn.Permissions.Extensions[passwordExtension]
+	if err = s.provider.CreateUser(ctx, ID, user, password); err != nil {
+		log.Err(err).Str("id", ID).Str("user", user).Msg("Could not create user, falling back to root")
+		user, password = "root", ""
+	}
+
 	// Create new client
-	c := newClient(sshConn, newSSHProxy(host, "root"), channelChan)
+	c := newClient(sshConn, newSSHProxy(host, user, password), channelChan, s.db, s.store, s.events, s.console, s.limits, s.forward)
+	c.hostID = ID
+	c.session.AddAuthAttempts(attempts...)
+	if err = c.session.Start(); err != nil {
+		// Nothing else of the session could be stored without it
+		log.Err(err).Str("id", ID).Msg("Could not insert session into DB, disconnecting")
+		conn.Close()
+		if err = s.provider.StopHost(context.TODO(), ID); err != nil {
+			log.Err(err).Str("id", ID).Msg("Could not stop host")
+		}
+		return
+	}
 
 	s.wg.Add(1)
+	activeSessions.Inc()
+	s.clientsMu.Lock()
+	s.clients[c.session.UID()] = c
+	s.clientsMu.Unlock()
 	go func() {
 		defer s.wg.Done()
 		c.handle(reqChan) // Blocks until client disconnects
+		activeSessions.Dec()
+		s.clientsMu.Lock()
+		delete(s.clients, c.session.UID())
+		s.clientsMu.Unlock()
 
 		stdout, timing, err := s.provider.GetScriptOutput(context.TODO(), ID)
 		if err != nil {
@@ -138,17 +288,128 @@ func (s *Server) handleClient(conn net.Conn) {
 		} else {
 			c.session.AddScriptOutput(stdout, timing)
 		}
+		s.collectArtifacts(ID, &c.session)
 
 		if err = s.provider.StopHost(context.TODO(), ID); err != nil {
 			log.Err(err).Str("id", ID).Msg("Could not stop host")
 		}
 
-		if err = s.db.BeginTx(c.session.Insert); err != nil {
-			log.Err(err).Str("id", ID).Msg("Could not insert data into DB")
+		if err = c.session.Close(); err != nil {
+			log.Err(err).Str("id", ID).Msg("Could not update session in DB")
 		}
 	}()
 }
 
+// collectArtifacts stores the files left behind on the host before it is torn down
+func (s *Server) collectArtifacts(id string, sess *session.Session) {
+	files, err := s.provider.GetArtifacts(context.TODO(), id)
+	if err != nil {
+		log.Err(err).Str("id", id).Msg("Could not get artifacts")
+		return
+	}
+
+	for _, f := range files {
+		a := artifact.Artifact{
+			TS:      time.Now(),
+			ModTime: f.ModTime,
+			Path:    f.Path,
+			Size:    f.Size,
+			Mode:    f.Mode,
+			Added:   f.Added,
+		}
+		if f.Data != nil {
+			hash, err := s.store.Put(f.Data)
+			if err != nil {
+				log.Err(err).Str("id", id).Str("path", f.Path).Msg("Could not store artifact")
+			} else {
+				a.SHA256 = &hash
+			}
+		}
+		sess.AddArtifacts(a)
+	}
+	log.Debug().Str("id", id).Int("artifacts", len(files)).Msg("Artifacts collected")
+}
+
 func (s *Server) pwCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
-	return nil, nil
+	n := s.auth.next(conn)
+	accepted := s.policy.Accept(auth.Attempt{
+		User:     conn.User(),
+		Password: string(password),
+		Method:   auth.MethodPassword,
+		Number:   n,
+	})
+	s.auth.add(conn, session.NewCredential(conn, string(password), n, accepted))
+	authAttempts.WithLabelValues(auth.MethodPassword, strconv.FormatBool(accepted)).Inc()
+
+	log.Debug().
+		Str("rAddr", conn.RemoteAddr().String()).
+		Str("user", conn.User()).
+		Str("password", string(password)).
+		Int("attempt", n).
+		Bool("accepted", accepted).
+		Msg("Password authentication attempt")
+	return authResult(accepted, string(password))
+}
+
+func (s *Server) pubKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	n := s.auth.next(conn)
+	accepted := s.policy.Accept(auth.Attempt{
+		User:   conn.User(),
+		Method: auth.MethodPublicKey,
+		Number: n,
+	})
+	a := session.NewPublicKey(conn, key, n, accepted)
+	s.auth.add(conn, a)
+	authAttempts.WithLabelValues(auth.MethodPublicKey, strconv.FormatBool(accepted)).Inc()
+
+	log.Debug().
+		Str("rAddr", conn.RemoteAddr().String()).
+		Str("user", conn.User()).
+		Str("keyType", a.KeyType).
+		Str("fingerprint", a.Fingerprint).
+		Int("attempt", n).
+		Bool("accepted", accepted).
+		Msg("Public key authentication attempt")
+	return authResult(accepted, "")
+}
+
+func (s *Server) keyboardInteractiveCallback(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	questions := []string{"Password: "}
+	answers, err := challenge(conn.User(), "", questions, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+
+	password := ""
+	if len(answers) > 0 {
+		password = answers[0]
+	}
+
+	n := s.auth.next(conn)
+	accepted := s.policy.Accept(auth.Attempt{
+		User:     conn.User(),
+		Password: password,
+		Method:   auth.MethodKeyboardInteractive,
+		Number:   n,
+	})
+	s.auth.add(conn, session.NewKeyboardInteractive(conn, "", questions, answers, n, accepted))
+	authAttempts.WithLabelValues(auth.MethodKeyboardInteractive, strconv.FormatBool(accepted)).Inc()
+
+	log.Debug().
+		Str("rAddr", conn.RemoteAddr().String()).
+		Str("user", conn.User()).
+		Strs("answers", answers).
+		Int("attempt", n).
+		Bool("accepted", accepted).
+		Msg("Keyboard-interactive authentication attempt")
+	return authResult(accepted, password)
+}
+
+// authResult returns the result of an authentication attempt. The password
+// is kept so that the same credentials can be used when logging in to the host.
+func authResult(accepted bool, password string) (*ssh.Permissions, error) {
+	if !accepted {
+		return nil, errPermissionDenied
+	}
+	return &ssh.Permissions{Extensions: map[string]string{passwordExtension: password}}, nil
 }
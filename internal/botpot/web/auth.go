@@ -0,0 +1,77 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type operatorKey struct{}
+
+// RequireToken only lets requests carrying the token through to h. The token
+// is either sent as a bearer token or, so that browsers can prompt for it,
+// as the password of basic authentication.
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, got, ok = r.BasicAuth()
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			unauthorized(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RequireOperator only lets requests of operators through to h. operators
+// maps the name of every operator to their token. The token is either sent
+// as a bearer token or as the password of basic authentication, with the
+// name of the operator as username. The name of the operator the token
+// belongs to is available to h through Operator.
+func RequireOperator(operators map[string]string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := authenticate(operators, r)
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorKey{}, name)))
+	})
+}
+
+// Operator returns the name of the operator authenticated by RequireOperator
+func Operator(ctx context.Context) string {
+	name, _ := ctx.Value(operatorKey{}).(string)
+	return name
+}
+
+// authenticate returns the name of the operator the request was authenticated as
+func authenticate(operators map[string]string, r *http.Request) (string, bool) {
+	if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		// Every token is compared so that the time taken
+		// does not tell which operator a token is close to
+		match := ""
+		for name, token := range operators {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				match = name
+			}
+		}
+		return match, match != ""
+	}
+
+	name, got, ok := r.BasicAuth()
+	token, known := operators[name]
+	if !ok || !known || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return "", false
+	}
+	return name, true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", "Bearer")
+	w.Header().Add("WWW-Authenticate", `Basic realm="botpot"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,65 @@
+// Package web serves the HTTP endpoints botpot exposes to its operators
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shutdownTimeout is how long to wait for requests to finish when stopping
+const shutdownTimeout = 5 * time.Second
+
+// Server is an HTTP server that other components register their handlers on
+type Server struct {
+	srv  *http.Server
+	mux  *http.ServeMux
+	addr string
+}
+
+// New creates a new server listening on addr
+func New(addr string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		mux:  mux,
+		addr: addr,
+	}
+}
+
+// Handle registers the handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start starts serving requests
+func (s *Server) Start() error {
+	log.Info().Msg("Starting HTTP Server")
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("addr", s.addr).Msg("Started listening")
+
+	go func() {
+		if err := s.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("HTTP Server failed")
+		}
+	}()
+	return nil
+}
+
+// Stop stops the server, waiting for ongoing requests to finish
+func (s *Server) Stop() error {
+	log.Info().Msg("Stopping HTTP Server")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}